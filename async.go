@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// asyncQueueSize is how many calls can wait for each worker before the parser blocks.
+const asyncQueueSize = 1000
+
+// errStopped is returned for calls made after the pipeline gave up on an earlier error.
+var errStopped = errors.New("stopped after an earlier error")
+
+// asyncBuilder decouples the parsing from the uploading: the calls of the StateMachine are queued
+// and performed by a bounded pool of workers. All calls for the same test go to the same worker,
+// so every test is started before its logs and finished after them. Launch and suite changes and
+// Finish wait for all queued calls first.
+type asyncBuilder struct {
+	inner    TestReportBuilder
+	queues   []chan func() error
+	pending  sync.WaitGroup
+	workers  sync.WaitGroup
+	noErrors bool
+
+	// launch, suite and tests mirror what was queued, the inner builder may not have caught up yet
+	launch string
+	suite  string
+	tests  map[string]bool
+
+	mu     sync.Mutex
+	errors []error
+}
+
+func newAsyncBuilder(inner TestReportBuilder, concurrency int, noErrors bool) *asyncBuilder {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	a := &asyncBuilder{inner: inner, noErrors: noErrors, tests: map[string]bool{}}
+	for i := 0; i < concurrency; i++ {
+		q := make(chan func() error, asyncQueueSize)
+		a.queues = append(a.queues, q)
+		a.workers.Add(1)
+		go a.work(q)
+	}
+	return a
+}
+
+func (a *asyncBuilder) work(q chan func() error) {
+	defer a.workers.Done()
+	for call := range q {
+		if !a.stopped() {
+			a.record(call())
+		}
+		a.pending.Done()
+	}
+}
+
+func (a *asyncBuilder) record(err error) {
+	if err == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.errors = append(a.errors, err)
+}
+
+func (a *asyncBuilder) stopped() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.noErrors && len(a.errors) > 0
+}
+
+// enqueue hands the call to the worker owning the test. Subtests go to the worker of their top-level test,
+// which starts the parents they are nested in.
+func (a *asyncBuilder) enqueue(name string, call func() error) error {
+	if a.stopped() {
+		return errStopped
+	}
+	root, _, _ := strings.Cut(name, "/")
+	h := fnv.New32a()
+	h.Write([]byte(root))
+	a.pending.Add(1)
+	a.queues[int(h.Sum32()%uint32(len(a.queues)))] <- call
+	return nil
+}
+
+// barrier waits until every queued call is done.
+func (a *asyncBuilder) barrier() error {
+	a.pending.Wait()
+	if a.stopped() {
+		return errStopped
+	}
+	return nil
+}
+
+func (a *asyncBuilder) getLaunch(name string) int {
+	if a.launch != "" {
+		return 0
+	}
+	return -1
+}
+
+func (a *asyncBuilder) getCase(name string) int {
+	if a.tests[name] {
+		return 0
+	}
+	return -1
+}
+
+func (a *asyncBuilder) EnsureLaunch(name, suite, startTime string) error {
+	if a.launch == name && a.suite == suite {
+		return nil
+	}
+	if err := a.barrier(); err != nil {
+		return err
+	}
+	if err := a.inner.EnsureLaunch(name, suite, startTime); err != nil {
+		return err
+	}
+	if a.suite != suite {
+		a.tests = map[string]bool{}
+	}
+	a.launch, a.suite = name, suite
+	return nil
+}
+
+func (a *asyncBuilder) EnsureTest(name, startTime string) error {
+	a.tests[name] = true
+	return a.enqueue(name, func() error { return a.inner.EnsureTest(name, startTime) })
+}
+
+func (a *asyncBuilder) AddLine(name, startTime, level, message string) error {
+	a.tests[name] = true
+	return a.enqueue(name, func() error { return a.inner.AddLine(name, startTime, level, message) })
+}
+
+func (a *asyncBuilder) FinnishTest(name, startTime, result, t string) error {
+	a.tests[name] = true
+	return a.enqueue(name, func() error { return a.inner.FinnishTest(name, startTime, result, t) })
+}
+
+func (a *asyncBuilder) EnsureStep(name, step, startTime string) error {
+	a.tests[name] = true
+	return a.enqueue(name, func() error { return a.inner.EnsureStep(name, step, startTime) })
+}
+
+func (a *asyncBuilder) FinnishStep(name, step, endTime, result string) error {
+	a.tests[name] = true
+	return a.enqueue(name, func() error { return a.inner.FinnishStep(name, step, endTime, result) })
+}
+
+func (a *asyncBuilder) Attach(name, startTime, path string) error {
+	a.tests[name] = true
+	return a.enqueue(name, func() error { return a.inner.Attach(name, startTime, path) })
+}
+
+func (a *asyncBuilder) Finish(t string) error {
+	if err := a.barrier(); err != nil {
+		return err
+	}
+	return a.inner.Finish(t)
+}
+
+// Close stops the workers once all queued calls are done, returning the errors they ran into.
+func (a *asyncBuilder) Close() []error {
+	for _, q := range a.queues {
+		close(q)
+	}
+	a.workers.Wait()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.errors
+}
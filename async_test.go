@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// lockedBuilder serializes the calls of the workers, the MockReportBuilder is not safe for concurrent use.
+type lockedBuilder struct {
+	mu    sync.Mutex
+	inner TestReportBuilder
+}
+
+func (l *lockedBuilder) getLaunch(name string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.getLaunch(name)
+}
+
+func (l *lockedBuilder) getCase(name string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.getCase(name)
+}
+
+func (l *lockedBuilder) EnsureTest(name, startTime string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.EnsureTest(name, startTime)
+}
+
+func (l *lockedBuilder) AddLine(name, startTime, level, message string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.AddLine(name, startTime, level, message)
+}
+
+func (l *lockedBuilder) FinnishTest(name, startTime, result, t string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.FinnishTest(name, startTime, result, t)
+}
+
+func (l *lockedBuilder) EnsureStep(name, step, startTime string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.EnsureStep(name, step, startTime)
+}
+
+func (l *lockedBuilder) FinnishStep(name, step, endTime, result string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.FinnishStep(name, step, endTime, result)
+}
+
+func (l *lockedBuilder) Attach(name, startTime, path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.Attach(name, startTime, path)
+}
+
+func (l *lockedBuilder) Finish(t string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.Finish(t)
+}
+
+func (l *lockedBuilder) EnsureLaunch(name, suite, startTime string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inner.EnsureLaunch(name, suite, startTime)
+}
+
+// eventRecorder gives every test and step item its own id, recording what happened to each of them.
+// The suite and launch are left to registerPortal.
+type eventRecorder struct {
+	mu sync.Mutex
+	// ids are the paths of the items, the name prefixed with the path of the parent
+	ids     map[string]string
+	parents map[string]string
+	events  map[string][]string
+}
+
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{ids: map[string]string{}, parents: map[string]string{}, events: map[string][]string{}}
+}
+
+func (r *eventRecorder) add(item, event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[item] = append(r.events[item], event)
+}
+
+func (r *eventRecorder) register() {
+	reItem := regexp.MustCompile(`/item/([^/]+)$`)
+	start := func(req *http.Request) (*http.Response, error) {
+		item := &RPItem{}
+		Expect(json.NewDecoder(req.Body).Decode(item)).To(Succeed())
+		r.mu.Lock()
+		id := fmt.Sprintf("item-%d", len(r.ids))
+		r.ids[id] = item.Name
+		r.parents[id] = reItem.FindStringSubmatch(req.URL.Path)[1]
+		if parent, ok := r.ids[r.parents[id]]; ok {
+			r.ids[id] = parent + "/" + item.Name
+		}
+		r.mu.Unlock()
+		r.add(id, "start")
+		return httpmock.NewJsonResponse(200, map[string]string{"id": id})
+	}
+	// tests are started in the suite testid, steps in their test
+	httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item/testid", start)
+	httpmock.RegisterRegexpResponder("POST", regexp.MustCompile(`/api/v2/TEST_PROJECT/item/[^/]+$`), start)
+	httpmock.RegisterRegexpResponder("PUT", regexp.MustCompile(`/api/v1/TEST_PROJECT/item/[^/]+$`),
+		func(req *http.Request) (*http.Response, error) {
+			f := &RPFinishItem{}
+			Expect(json.NewDecoder(req.Body).Decode(f)).To(Succeed())
+			r.add(reItem.FindStringSubmatch(req.URL.Path)[1], "finish "+f.Status)
+			return httpmock.NewJsonResponse(200, map[string]string{"id": "testid"})
+		})
+	var batches [][]*RPLog
+	record := batchRecorder(&batches)
+	httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log",
+		func(req *http.Request) (*http.Response, error) {
+			r.mu.Lock()
+			resp, err := record(req)
+			logs := batches[len(batches)-1]
+			r.mu.Unlock()
+			for _, l := range logs {
+				// the lines logged after their test finished go to the launch
+				if l.ItemUUID != "" {
+					r.add(l.ItemUUID, "log")
+				}
+			}
+			return resp, err
+		})
+}
+
+var _ = Describe("Testing concurrent upload", func() {
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		registerPortal()
+	})
+
+	It("Reports the same cases as the serial parsing", func() {
+		actual := &MockReportBuilder{Cases: CasesType{}}
+		a := newAsyncBuilder(&lockedBuilder{inner: actual}, 4, true)
+		errP := process(a, "TestName", "TestSuite", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{Format: formatAuto, NoErrors: true})
+		Expect(mergeErrors(a.Close(), errP)).To(BeNil())
+		expectGolden(actual, "./test_data/parallel-kuttl.json")
+		Expect(actual.FinishStamp).NotTo(BeEmpty())
+	})
+
+	It("Keeps the order of the requests of every test", func() {
+		upload := func(concurrency int) map[string][]string {
+			httpmock.Reset()
+			registerPortal()
+			r := newEventRecorder()
+			r.register()
+			lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+			lg.LaunchLogs = false
+			lg.Batch = LogBatchOptions{MaxCount: 1, MaxBytes: 1 << 20, MaxWait: time.Hour}
+			var builder TestReportBuilder = lg
+			var a *asyncBuilder
+			if concurrency > 1 {
+				a = newAsyncBuilder(lg, concurrency, true)
+				builder = a
+			}
+			err := process(builder, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+				&ParseOptions{NoErrors: true})
+			if a != nil {
+				err = mergeErrors(a.Close(), err)
+			}
+			Expect(err).To(BeNil())
+			byName := map[string][]string{}
+			for id, events := range r.events {
+				Expect(events[0]).To(Equal("start"))
+				byName[r.ids[id]] = events
+			}
+			return byName
+		}
+		serial := upload(1)
+		Expect(serial).NotTo(BeEmpty())
+		Expect(upload(4)).To(Equal(serial))
+	})
+
+	It("Waits for in-flight work before finishing", func() {
+		actual := &MockReportBuilder{Cases: CasesType{}}
+		slow := &lockedBuilder{inner: actual}
+		a := newAsyncBuilder(slow, 2, false)
+		Expect(a.EnsureLaunch("launch", "suite", "2023-11-21T00:17:10Z")).To(Succeed())
+		slow.mu.Lock()
+		for i := 0; i < 10; i++ {
+			Expect(a.AddLine(fmt.Sprintf("test-%d", i), "2023-11-21T00:17:11Z", "info", "hello")).To(Succeed())
+		}
+		finished := make(chan error)
+		go func() { finished <- a.Finish("2023-11-21T00:17:12Z") }()
+		Consistently(finished).ShouldNot(Receive())
+		slow.mu.Unlock()
+		Eventually(finished).Should(Receive(BeNil()))
+		Expect(actual.Cases).To(HaveLen(10))
+		Expect(actual.FinishStamp).To(Equal("2023-11-21T00:17:12Z"))
+		Expect(a.Close()).To(BeEmpty())
+	})
+
+	It("Stops taking calls after the first error unless asked to go on", func() {
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item/testid",
+			httpmock.NewStringResponder(500, `{"errorCode": 5000, "message": "boom"}`))
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		a := newAsyncBuilder(lg, 4, false)
+		errP := process(a, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{})
+		err := mergeErrors(a.Close(), errP)
+		var report *ErrorReport
+		Expect(errors.As(err, &report)).To(BeTrue())
+		Expect(report.Errors).NotTo(BeEmpty())
+		for _, e := range report.Errors {
+			var pe *PortalError
+			Expect(errors.As(e, &pe)).To(BeTrue())
+			Expect(pe.StatusCode).To(Equal(500))
+		}
+	})
+})
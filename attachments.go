@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// reAttach is the log-line convention for attaching a file to the current test, i.e. t.Log("ATTACH: must-gather.tgz").
+var reAttach = regexp.MustCompile(`^\s*ATTACH: (?P<path>.*\S)\s*$`)
+
+// DefaultAttachmentPattern matches the files in the -attachments dir to tests by their first directory
+// or their name without the extension, i.e. 1-009_validate/pods.yaml or TestFoo.must-gather.tgz.
+const DefaultAttachmentPattern = `^(?P<test>[^/.]+)[/.]`
+
+// RPFile names the file part sent along with a log, the log is shown with the file attached.
+type RPFile struct {
+	Name string `json:"name"`
+}
+
+// attachmentBody streams the multipart request with the file, instead of loading it into memory.
+// A retried request restarts the stream by reopening the file.
+type attachmentBody struct {
+	log      *RPLog
+	path     string
+	boundary string
+	mu       sync.Mutex
+	r        *io.PipeReader
+}
+
+func newAttachmentBody(l *RPLog, path string) *attachmentBody {
+	return &attachmentBody{log: l, path: path, boundary: multipart.NewWriter(io.Discard).Boundary()}
+}
+
+func (b *attachmentBody) contentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+func (b *attachmentBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.r == nil {
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(b.write(w))
+		}()
+		b.r = r
+	}
+	r := b.r
+	b.mu.Unlock()
+	return r.Read(p)
+}
+
+// rewind stops the current stream, the next Read starts over.
+func (b *attachmentBody) rewind() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.r != nil {
+		b.r.Close()
+		b.r = nil
+	}
+}
+
+func (b *attachmentBody) write(w io.Writer) error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(b.boundary); err != nil {
+		return err
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="json_request_part"`)
+	h.Set("Content-Type", "application/json")
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(part).Encode([]*RPLog{b.log}); err != nil {
+		return err
+	}
+	h = textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, b.log.File.Name))
+	h.Set("Content-Type", attachmentType(b.path))
+	part, err = mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// attachmentType guesses the content type from the extension, yaml dumps are shown as text.
+func attachmentType(path string) string {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".log", ".txt":
+		return "text/plain"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// checkAttachment makes sure the file can be attached before anything is reported.
+func checkAttachment(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return &InputError{Field: "attachment", Value: path, Err: err}
+	}
+	if info.IsDir() {
+		return &InputError{Field: "attachment", Value: path, Err: fmt.Errorf("is a directory")}
+	}
+	return nil
+}
+
+func (p *RPLogger) Attach(name, startTime, path string) error {
+	if err := checkAttachment(path); err != nil {
+		return err
+	}
+	if err := p.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	item := p.logItem(name)
+	uuid, err := p.newUUID()
+	if err != nil {
+		return err
+	}
+	l := &RPLog{
+		LaunchUUID: p.launch.UUID,
+		ItemUUID:   item,
+		Time:       startTime,
+		Message:    filepath.Base(path),
+		Level:      "info",
+		UUID:       uuid,
+		File:       &RPFile{Name: filepath.Base(path)},
+	}
+	body := newAttachmentBody(l, path)
+	defer body.rewind()
+	url := fmt.Sprintf("api/v2/%s/log", p.project)
+	resp, err := p.requestWithAuth().
+		SetHeader("Content-Type", body.contentType()).
+		SetBody(body).
+		AddRetryCondition(func(*resty.Response, error) bool {
+			// resty has no per-request hook between attempts, the next one needs to read the file again
+			body.rewind()
+			return false
+		}).
+		Post(url)
+	return withItem(name+" "+filepath.Base(path), checkResponse(http.MethodPost, url, resp, err))
+}
+
+// attachmentDir is a TestReportBuilder attaching the files of a directory to the tests they are named after,
+// when the tests finish, as the portal takes no logs for finished items.
+type attachmentDir struct {
+	TestReportBuilder
+	dir     string
+	pattern *regexp.Regexp
+	// files are the files of the directory by their test, until they are attached
+	files map[string][]string
+	// attached are the real paths of the files attached to every test, a file named by an ATTACH: line
+	// and found in the directory as well is uploaded once
+	attached map[string]map[string]bool
+}
+
+func newAttachmentDir(inner TestReportBuilder, dir, pattern string) (*attachmentDir, error) {
+	if pattern == "" {
+		pattern = DefaultAttachmentPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &InputError{Field: "attachment pattern", Value: pattern, Err: err}
+	}
+	if re.SubexpIndex("test") < 0 {
+		return nil, &InputError{Field: "attachment pattern", Value: pattern,
+			Err: fmt.Errorf("needs a test capture group, i.e. (?P<test>...)")}
+	}
+	a := &attachmentDir{TestReportBuilder: inner, dir: dir, pattern: re, attached: map[string]map[string]bool{}}
+	if a.files, err = a.walk(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// walk maps the files of the directory to the tests their path names, skipping the files without one.
+func (a *attachmentDir) walk() (map[string][]string, error) {
+	files := map[string][]string{}
+	err := filepath.WalkDir(a.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, path)
+		if err != nil {
+			return err
+		}
+		test := getMatches(a.pattern, filepath.ToSlash(rel))["test"]
+		if test == "" {
+			fmt.Fprintf(os.Stderr, "No test for attachment %s\n", rel)
+			return nil
+		}
+		files[test] = append(files[test], path)
+		return nil
+	})
+	if err != nil {
+		return nil, &InputError{Field: "attachments", Value: a.dir, Err: err}
+	}
+	return files, nil
+}
+
+// attach attaches the files of the test, once.
+func (a *attachmentDir) attach(test, t string) error {
+	paths := a.files[test]
+	delete(a.files, test)
+	for _, path := range paths {
+		if err := a.Attach(test, t, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attach skips the files already attached to the test.
+func (a *attachmentDir) Attach(name, startTime, path string) error {
+	real, err := realPath(path)
+	if err != nil {
+		// the inner builder reports the missing file
+		return a.TestReportBuilder.Attach(name, startTime, path)
+	}
+	if a.attached[name][real] {
+		return nil
+	}
+	if err := a.TestReportBuilder.Attach(name, startTime, path); err != nil {
+		return err
+	}
+	if a.attached[name] == nil {
+		a.attached[name] = map[string]bool{}
+	}
+	a.attached[name][real] = true
+	return nil
+}
+
+func (a *attachmentDir) FinnishTest(name, startTime, result, t string) error {
+	if err := a.attach(name, startTime); err != nil {
+		return err
+	}
+	return a.TestReportBuilder.FinnishTest(name, startTime, result, t)
+}
+
+// Finish attaches the files of the tests which did not finish, the files of unknown tests are skipped.
+func (a *attachmentDir) Finish(t string) error {
+	tests := make([]string, 0, len(a.files))
+	for test := range a.files {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	for _, test := range tests {
+		if a.getCase(test) < 0 {
+			for _, path := range a.files[test] {
+				rel, _ := filepath.Rel(a.dir, path)
+				fmt.Fprintf(os.Stderr, "No test for attachment %s\n", rel)
+			}
+			continue
+		}
+		if err := a.attach(test, t); err != nil {
+			return err
+		}
+	}
+	return a.TestReportBuilder.Finish(t)
+}
+
+// attachmentRoots is a TestReportBuilder only attaching the files inside its directories, the ones of the log and
+// of -attachments, so that an ATTACH: line of an untrusted log can not upload i.e. ~/.kube/config.
+// The relative paths are looked up in the directories first, wherever the log is parsed from.
+type attachmentRoots struct {
+	TestReportBuilder
+	roots []string
+}
+
+func newAttachmentRoots(inner TestReportBuilder, dirs ...string) (*attachmentRoots, error) {
+	a := &attachmentRoots{TestReportBuilder: inner}
+	for _, dir := range dirs {
+		root, err := realPath(dir)
+		if err != nil {
+			return nil, &InputError{Field: "attachments", Value: dir, Err: err}
+		}
+		a.roots = append(a.roots, root)
+	}
+	return a, nil
+}
+
+// realPath is the absolute path without any symlinks, those could point out of the roots.
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// inside tells whether the path is one of the roots or below them.
+func (a *attachmentRoots) inside(path string) bool {
+	for _, root := range a.roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve finds a relative path in the directory of the log, then in the -attachments dir,
+// the path is taken as it is when it is in neither of them.
+func (a *attachmentRoots) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	for _, root := range a.roots {
+		if _, err := os.Lstat(filepath.Join(root, path)); err == nil {
+			return filepath.Join(root, path)
+		}
+	}
+	return path
+}
+
+func (a *attachmentRoots) Attach(name, startTime, path string) error {
+	if len(a.roots) == 0 {
+		return &InputError{Field: "attachment", Value: path,
+			Err: fmt.Errorf("needs -attachments when the log is read from stdin")}
+	}
+	path = a.resolve(path)
+	real, err := realPath(path)
+	if err != nil {
+		return &InputError{Field: "attachment", Value: path, Err: err}
+	}
+	if !a.inside(real) {
+		return &InputError{Field: "attachment", Value: path,
+			Err: fmt.Errorf("is outside of %s", strings.Join(a.roots, " and "))}
+	}
+	return a.TestReportBuilder.Attach(name, startTime, path)
+}
+
+// addLine reports the log line, or attaches the file it names by the ATTACH: convention.
+// Every line reported by the parsers goes through it, whichever rule or format it came from.
+func addLine(lg TestReportBuilder, name, startTime, level, message string) error {
+	if path := getMatches(reAttach, message)["path"]; path != "" {
+		return lg.Attach(name, startTime, path)
+	}
+	return lg.AddLine(name, startTime, level, message)
+}
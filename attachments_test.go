@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/go-resty/resty/v2"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// uploadedFile is an attachment as received by the portal.
+type uploadedFile struct {
+	Log         *RPLog
+	ContentType string
+	Size        int64
+	Content     string
+}
+
+// fileRecorder keeps the files sent to the log endpoint, the logs sent without a file are ignored.
+func fileRecorder(files *[]uploadedFile) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		Expect(err).To(BeNil())
+		r := multipart.NewReader(req.Body, params["boundary"])
+		part, err := r.NextPart()
+		Expect(err).To(BeNil())
+		logs := []*RPLog{}
+		Expect(json.NewDecoder(part).Decode(&logs)).To(Succeed())
+		part, err = r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return httpmock.NewJsonResponse(201, map[string]any{"responses": []any{}})
+		}
+		Expect(err).To(BeNil())
+		Expect(logs).To(HaveLen(1))
+		Expect(part.FormName()).To(Equal("file"))
+		Expect(part.FileName()).To(Equal(logs[0].File.Name))
+		content := &strings.Builder{}
+		size, err := io.Copy(content, io.LimitReader(part, 1<<10))
+		Expect(err).To(BeNil())
+		rest, err := io.Copy(io.Discard, part)
+		Expect(err).To(BeNil())
+		*files = append(*files, uploadedFile{
+			Log: logs[0], ContentType: part.Header.Get("Content-Type"), Size: size + rest, Content: content.String(),
+		})
+		return httpmock.NewJsonResponse(201, map[string]any{"responses": []any{}})
+	}
+}
+
+var _ = Describe("Testing attachments", func() {
+	var files []uploadedFile
+
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		files = nil
+		registerPortal()
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log", fileRecorder(&files))
+	})
+
+	It("Attaches the files named by ATTACH: lines to the test or its open step", func() {
+		tree := NewReportTree()
+		errP := process(tree, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/kuttl-attach.txt"),
+			&ParseOptions{NoErrors: true})
+		var report *ErrorReport
+		Expect(errors.As(errP, &report)).To(BeTrue())
+		Expect(report.Errors).To(HaveLen(1))
+		Expect(report.Errors[0]).To(MatchError(ContainSubstring(
+			`line 16: bad attachment "test_data/attachments/missing.yaml"`)))
+		Expect(tree.suite.Tests[tree.getCase("1-001_install")].Attachments).To(Equal(
+			[]string{"test_data/attachments/1-001_install/argocd.yaml"}))
+		Expect(tree.suite.Tests[tree.getCase("1-001_install")].Logs).To(HaveLen(4))
+	})
+
+	It("Attaches the files named by the messages of junit failures", func() {
+		tree := NewReportTree()
+		xml := `<testsuite name="e2e" timestamp="2023-11-21T00:17:10Z"><testcase name="1-001_install" time="1">` +
+			`<failure message="ATTACH: test_data/attachments/1-001_install/argocd.yaml"></failure></testcase></testsuite>`
+		Expect(process(tree, "REPORT_NAME", "REPORT_SUITE", script.Echo(xml), &ParseOptions{Format: formatJUnit})).To(
+			Succeed())
+		test := tree.suite.Tests[tree.getCase("1-001_install")]
+		Expect(test.Attachments).To(Equal([]string{"test_data/attachments/1-001_install/argocd.yaml"}))
+		Expect(test.Logs).To(BeEmpty())
+	})
+
+	It("Uploads the attachment together with its log", func() {
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		lg.newUUID = sequentialUUIDs()
+		errP := process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/kuttl-attach.txt"),
+			&ParseOptions{NoErrors: true})
+		Expect(errP).To(MatchError(ContainSubstring("bad attachment")))
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Log).To(Equal(&RPLog{
+			LaunchUUID: "testid", ItemUUID: "testid", Time: "2023-11-21T00:19:34Z", Message: "argocd.yaml",
+			Level: "info", UUID: files[0].Log.UUID, File: &RPFile{Name: "argocd.yaml"},
+		}))
+		Expect(files[0].ContentType).To(Equal("text/plain"))
+		content, err := os.ReadFile("./test_data/attachments/1-001_install/argocd.yaml")
+		Expect(err).To(BeNil())
+		Expect(files[0].Content).To(Equal(string(content)))
+	})
+
+	It("Attaches the files of the directory to the tests they are named after", func() {
+		tree := NewReportTree()
+		attachments, err := newAttachmentDir(tree, "./test_data/attachments", "")
+		Expect(err).To(BeNil())
+		_ = process(attachments, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/kuttl-attach.txt"),
+			&ParseOptions{NoErrors: true})
+		// named by an ATTACH: line and found in the directory, it is attached once
+		Expect(tree.suite.Tests[tree.getCase("1-001_install")].Attachments).To(Equal(
+			[]string{"test_data/attachments/1-001_install/argocd.yaml"}))
+		Expect(tree.suite.Tests[tree.getCase("1-002_label")].Attachments).To(Equal(
+			[]string{"test_data/attachments/1-002_label.must-gather.txt"}))
+		Expect(tree.getCase("unrelated")).To(Equal(-1))
+	})
+
+	It("Only attaches the files inside the directory of the log or the attachments", func() {
+		tmp := GinkgoT().TempDir()
+		logs, outside := filepath.Join(tmp, "logs"), filepath.Join(tmp, "outside")
+		Expect(os.Mkdir(logs, 0o777)).To(Succeed())
+		Expect(os.Mkdir(outside, 0o777)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(logs, "pods.yaml"), []byte("pods"), 0o666)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(outside, "kubeconfig"), []byte("secret"), 0o666)).To(Succeed())
+		Expect(os.Symlink(filepath.Join(outside, "kubeconfig"), filepath.Join(logs, "link"))).To(Succeed())
+		goMod, err := filepath.Abs("go.mod")
+		Expect(err).To(BeNil())
+		log := strings.Join([]string{
+			`=== RUN   kuttl/harness/1-001_install`,
+			`    logger.go:42: 00:19:32 | 1-001_install | ATTACH: ` + filepath.Join(logs, "pods.yaml"),
+			`    logger.go:42: 00:19:33 | 1-001_install | ATTACH: ` + logs + `/../outside/kubeconfig`,
+			`    logger.go:42: 00:19:34 | 1-001_install | ATTACH: ` + filepath.Join(logs, "link"),
+			`    logger.go:42: 00:19:35 | 1-001_install | ATTACH: ` + goMod,
+			`        --- PASS: kuttl/harness/1-001_install (40.12s)`,
+		}, "\n")
+
+		tree := NewReportTree()
+		roots, err := newAttachmentRoots(tree, logs)
+		Expect(err).To(BeNil())
+		errP := process(roots, "REPORT_NAME", "REPORT_SUITE", script.Echo(log), &ParseOptions{NoErrors: true,
+			StartTime: time.Date(2023, 11, 21, 0, 17, 10, 0, time.UTC)})
+		var report *ErrorReport
+		Expect(errors.As(errP, &report)).To(BeTrue())
+		Expect(report.Errors).To(HaveLen(3))
+		for _, err := range report.Errors {
+			Expect(err).To(MatchError(ContainSubstring("is outside of")))
+		}
+		Expect(tree.suite.Tests[tree.getCase("1-001_install")].Attachments).To(Equal(
+			[]string{filepath.Join(logs, "pods.yaml")}))
+
+		stdin, err := newAttachmentRoots(NewReportTree())
+		Expect(err).To(BeNil())
+		Expect(stdin.Attach("1-001_install", "2023-11-21T00:19:32Z", filepath.Join(logs, "pods.yaml"))).To(
+			MatchError(ContainSubstring("needs -attachments")))
+	})
+
+	It("Rejects a pattern without a test group", func() {
+		_, err := newAttachmentDir(NewReportTree(), "./test_data/attachments", `^(?P<name>[^/]+)/`)
+		Expect(err).To(MatchError(ContainSubstring("needs a test capture group")))
+	})
+
+	It("Streams big files and reads them again when retrying", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "must-gather.tar.gz")
+		Expect(os.WriteFile(path, make([]byte, 8<<20), 0o666)).To(Succeed())
+
+		retrying := resty.New().SetBaseURL("http://portal/")
+		httpmock.ActivateNonDefault(retrying.GetClient())
+		configureRetries(retrying, 2, 10*time.Millisecond).SetRetryWaitTime(time.Millisecond)
+		lg := NewRPLogger(retrying, "TOKEN", "TEST_PROJECT")
+		calls := 0
+		record := fileRecorder(&files)
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log",
+			func(req *http.Request) (*http.Response, error) {
+				calls++
+				if calls == 1 {
+					// the portal gave up in the middle of the upload
+					_, _ = io.CopyN(io.Discard, req.Body, 1<<20)
+					return httpmock.NewStringResponse(http.StatusServiceUnavailable, ""), nil
+				}
+				return record(req)
+			})
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.Attach("TestUpgrade", "2023-11-21T00:17:11Z", path)).To(Succeed())
+		Expect(calls).To(Equal(2))
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Size).To(Equal(int64(8 << 20)))
+	})
+})
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RPAttribute tags a launch or item, a value without a key is allowed as well.
+type RPAttribute struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+// DefaultTestAttributePatterns tag kuttl tests with their parallel group, i.e. 1 for 1-009_validate.
+var DefaultTestAttributePatterns = []*regexp.Regexp{regexp.MustCompile(`^(?P<group>\d+)-`)}
+
+// AttrFlag collects repeated -attr key:value flags.
+type AttrFlag []RPAttribute
+
+func (f *AttrFlag) String() string {
+	attrs := []string{}
+	for _, a := range *f {
+		attrs = append(attrs, a.Key+":"+a.Value)
+	}
+	return strings.Join(attrs, ",")
+}
+
+func (f *AttrFlag) Set(value string) error {
+	key, v, found := strings.Cut(value, ":")
+	if !found {
+		key, v = "", value
+	}
+	if v == "" {
+		return &InputError{Field: "attribute", Value: value, Err: fmt.Errorf("expected key:value or value")}
+	}
+	*f = append(*f, RPAttribute{Key: key, Value: v})
+	return nil
+}
+
+// PatternFlag collects repeated regex flags, every named capture group becomes an attribute.
+type PatternFlag []*regexp.Regexp
+
+func (f *PatternFlag) String() string {
+	patterns := []string{}
+	for _, re := range *f {
+		patterns = append(patterns, re.String())
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (f *PatternFlag) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return &InputError{Field: "attribute pattern", Value: value, Err: err}
+	}
+	named := false
+	for _, n := range re.SubexpNames() {
+		named = named || n != ""
+	}
+	if !named {
+		return &InputError{Field: "attribute pattern", Value: value,
+			Err: fmt.Errorf("needs a named capture group, i.e. (?P<version>...)")}
+	}
+	*f = append(*f, re)
+	return nil
+}
+
+// captureAttributes turns the named captures of the matching patterns into attributes,
+// in the order of the patterns and of their groups.
+func captureAttributes(patterns []*regexp.Regexp, s string) []RPAttribute {
+	var attrs []RPAttribute
+	for _, re := range patterns {
+		m := getMatches(re, s)
+		for _, key := range re.SubexpNames() {
+			if value := m[key]; key != "" && value != "" {
+				attrs = mergeAttributes(attrs, RPAttribute{Key: key, Value: value})
+			}
+		}
+	}
+	return attrs
+}
+
+// mergeAttributes adds the attributes, replacing the value of the ones with the same key.
+func mergeAttributes(attrs []RPAttribute, more ...RPAttribute) []RPAttribute {
+	merged := append([]RPAttribute{}, attrs...)
+	for _, a := range more {
+		replaced := false
+		for i := range merged {
+			if a.Key != "" && merged[i].Key == a.Key {
+				merged[i].Value = a.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, a)
+		}
+	}
+	return merged
+}
+
+// AttributeScanner collects launch attributes from the raw input, including the lines which are not part of a test.
+type AttributeScanner struct {
+	Patterns []*regexp.Regexp
+	mu       sync.Mutex
+	found    []RPAttribute
+}
+
+func (s *AttributeScanner) scan(line string) {
+	if attrs := captureAttributes(s.Patterns, line); len(attrs) > 0 {
+		s.mu.Lock()
+		s.found = mergeAttributes(s.found, attrs...)
+		s.mu.Unlock()
+	}
+}
+
+// Attributes returns the attributes found so far.
+func (s *AttributeScanner) Attributes() []RPAttribute {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RPAttribute{}, s.found...)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/bitfield/script"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// attributeRecorder keeps the attributes the launch was started and finished with, and the ones of every test.
+type attributeRecorder struct {
+	mu     sync.Mutex
+	start  []RPAttribute
+	finish []RPAttribute
+	tests  map[string][]RPAttribute
+}
+
+func (r *attributeRecorder) register() {
+	r.tests = map[string][]RPAttribute{}
+	httpmock.RegisterResponder("POST", "http://portal/api/v1/TEST_PROJECT/launch",
+		func(req *http.Request) (*http.Response, error) {
+			l := &RPLaunch{}
+			Expect(json.NewDecoder(req.Body).Decode(l)).To(Succeed())
+			r.start = l.Attributes
+			return httpmock.NewJsonResponse(200, map[string]string{"id": "testid"})
+		})
+	httpmock.RegisterResponder("PUT", "http://portal/api/v1/TEST_PROJECT/launch/testid/finish",
+		func(req *http.Request) (*http.Response, error) {
+			i := &RPItem{}
+			Expect(json.NewDecoder(req.Body).Decode(i)).To(Succeed())
+			r.finish = i.Attributes
+			return httpmock.NewJsonResponse(200, map[string]string{"id": "testid"})
+		})
+	httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item/testid",
+		func(req *http.Request) (*http.Response, error) {
+			i := &RPItem{}
+			Expect(json.NewDecoder(req.Body).Decode(i)).To(Succeed())
+			r.mu.Lock()
+			r.tests[i.Name] = i.Attributes
+			r.mu.Unlock()
+			return httpmock.NewJsonResponse(200, map[string]string{"id": "testid"})
+		})
+}
+
+var _ = Describe("Testing attributes", func() {
+	var r *attributeRecorder
+
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		registerPortal()
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log", mockOkJSON(nil))
+		r = &attributeRecorder{}
+		r.register()
+	})
+
+	DescribeTable("Parsing -attr", func(value string, expected RPAttribute) {
+		f := AttrFlag{}
+		Expect(f.Set(value)).To(Succeed())
+		Expect(f).To(Equal(AttrFlag{expected}))
+	},
+		Entry("key and value", "env:ci", RPAttribute{Key: "env", Value: "ci"}),
+		Entry("only a value", "nightly", RPAttribute{Value: "nightly"}),
+		Entry("a value with a colon", "url:http://x", RPAttribute{Key: "url", Value: "http://x"}),
+	)
+
+	DescribeTable("Rejecting bad flags", func(set func() error, expected string) {
+		Expect(set()).To(MatchError(ContainSubstring(expected)))
+	},
+		Entry("-attr without a value", func() error { return (&AttrFlag{}).Set("env:") }, "expected key:value"),
+		Entry("-attrPattern which does not compile", func() error { return (&PatternFlag{}).Set("(") },
+			"bad attribute pattern"),
+		Entry("-attrPattern without a named group", func() error { return (&PatternFlag{}).Set("ARGOCD_VERSION=.*") },
+			"needs a named capture group"),
+	)
+
+	It("Merges attributes with the same key", func() {
+		Expect(mergeAttributes([]RPAttribute{{Key: "env", Value: "ci"}, {Value: "nightly"}},
+			RPAttribute{Key: "env", Value: "prow"}, RPAttribute{Key: "argocd", Value: "2.6.15"})).To(Equal(
+			[]RPAttribute{{Key: "env", Value: "prow"}, {Value: "nightly"}, {Key: "argocd", Value: "2.6.15"}}))
+	})
+
+	It("Captures the attributes in the order of the patterns and their groups", func() {
+		patterns := []*regexp.Regexp{
+			regexp.MustCompile(`(?P<z>\w+) (?P<a>\w+) (?P<m>\w+)`), regexp.MustCompile(`(?P<b>\w+)$`),
+		}
+		for i := 0; i < 20; i++ {
+			Expect(captureAttributes(patterns, "1 2 3 4")).To(Equal([]RPAttribute{
+				{Key: "z", Value: "1"}, {Key: "a", Value: "2"}, {Key: "m", Value: "3"}, {Key: "b", Value: "4"},
+			}))
+		}
+	})
+
+	It("Finds the argocd version before the first test", func() {
+		scanner := &AttributeScanner{Patterns: []*regexp.Regexp{regexp.MustCompile(`ARGOCD_VERSION=(?P<argocd>[\d.]+)$`)}}
+		_ = process(NewReportTree(), "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/argocd-e2e-186_last.log"),
+			&ParseOptions{NoErrors: true, Attributes: scanner})
+		Expect(scanner.Attributes()).To(Equal([]RPAttribute{{Key: "argocd", Value: "2.6.15"}}))
+	})
+
+	It("Starts the launch with the given attributes and finishes it with the ones found in the log", func() {
+		scanner := &AttributeScanner{Patterns: []*regexp.Regexp{regexp.MustCompile(`^\+ export CI=(?P<ci>\w+)$`)}}
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		lg.Attributes = []RPAttribute{{Key: "env", Value: "ci"}, {Value: "nightly"}}
+		lg.Scanner = scanner
+		errP := process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{NoErrors: true, Attributes: scanner})
+		Expect(errP).To(BeNil())
+		Expect(r.start).To(Equal([]RPAttribute{{Key: "env", Value: "ci"}, {Value: "nightly"}}))
+		Expect(r.finish).To(Equal([]RPAttribute{{Key: "env", Value: "ci"}, {Value: "nightly"}, {Key: "ci", Value: "prow"}}))
+	})
+
+	It("Tags kuttl tests with their group", func() {
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		errP := process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{NoErrors: true})
+		Expect(errP).To(BeNil())
+		Expect(r.tests).To(HaveKeyWithValue("1-009_validate-manage-other-namespace",
+			[]RPAttribute{{Key: "group", Value: "1"}}))
+		Expect(r.tests).To(HaveKeyWithValue("kuttl", BeEmpty()))
+		Expect(r.finish).To(BeEmpty())
+	})
+})
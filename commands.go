@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// ConnectionOptions are the flags of every command talking to the portal.
+type ConnectionOptions struct {
+	PortalURL string
+	Token     string
+	// TokenFile holds the token, TokenCommand prints it, they override a token of a lower settings layer
+	TokenFile    string
+	TokenCommand string
+	Project      string
+	SkipTLS      bool
+	Retries      int
+	RetryMaxWait time.Duration
+}
+
+// connectionFlags are the flags of ConnectionOptions, the commands only reading the portal take just them
+// from the config and the environment.
+var connectionFlags = []string{"url", "project", "tokenFile", "tokenCommand", "skipTls", "retries", "retryMaxWait"}
+
+// addFlags registers the connection flags.
+func (c *ConnectionOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Project, "project", "", "project of the portal")
+	fs.StringVar(&c.PortalURL, "url", "", "url of the report portal")
+	fs.StringVar(&c.TokenFile, "tokenFile", "", "file holding the token, i.e. a mounted secret, instead of RP_TOKEN")
+	fs.StringVar(&c.TokenCommand, "tokenCommand", "",
+		"credential helper printing the token, run by sh, i.e. 'pass show reportportal', instead of RP_TOKEN")
+	fs.BoolVar(&c.SkipTLS, "skipTls", false, "skip TLS checks")
+	fs.IntVar(&c.Retries, "retries", 3,
+		"how many times to retry requests failing with a network error or 429/502/503/504")
+	fs.DurationVar(&c.RetryMaxWait, "retryMaxWait", 30*time.Second, "longest wait between two retries")
+}
+
+// client connects to the portal, which has to be given by the flags or the config.
+func (c *ConnectionOptions) client() (*resty.Client, error) {
+	if c.PortalURL == "" {
+		return nil, &InputError{Field: "url",
+			Err: fmt.Errorf("no portal to talk to, pass -url, set RP_URL or url in the config")}
+	}
+	if c.Project == "" {
+		return nil, &InputError{Field: "project",
+			Err: fmt.Errorf("no project, pass -project, set RP_PROJECT or project in the config")}
+	}
+	client := resty.New()
+	client.SetBaseURL(c.PortalURL)
+	client.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: c.SkipTLS})
+	client.SetAuthToken(c.Token)
+	return configureRetries(client, c.Retries, c.RetryMaxWait), nil
+}
+
+// ListFlag collects a repeated flag, each of them can hold a comma separated list as well.
+type ListFlag []string
+
+func (f *ListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *ListFlag) Set(value string) error {
+	*f = append(*f, strings.Split(value, ",")...)
+	return nil
+}
+
+// command is a subcommand of the cli, run with the arguments following its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands are the subcommands, upload is run when the first argument is a flag already.
+var commands = []command{
+	{"upload", "parse a test log and report it to the portal", runUpload},
+	{"parse", "parse a test log and print what would be reported, offline", runParse},
+	{"launches", "list the launches of a project, searching by name or attribute", runLaunches},
+	{"delete", "delete launches by name or id", runDelete},
+	{"merge", "merge launches given by name or id into a new one", runMerge},
+	{"config", "config show prints the settings in effect and where they come from", runConfig},
+	{"fake-server", "serve an in-memory portal to try uploads against", runFakeServer},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nThe defaults of the flags are read from %s or -config, and from RP_* environment variables"+
+		" named like the flags, i.e. RP_SKIP_TLS for -skipTls.\n", defaultConfigPath())
+	fmt.Fprintf(os.Stderr, "Run %s <command> -h for the flags of a command.\n", filepath.Base(os.Args[0]))
+}
+
+// runCommand runs the command named by the first argument.
+func runCommand(args []string) error {
+	name := "upload"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+	usage()
+	return &InputError{Field: "command", Value: name, Err: fmt.Errorf("unknown command")}
+}
+
+// addParseFlags registers the flags of reading a log, shared by upload and parse.
+func (o *UploadOptions) addParseFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.LogFile, "file", "", "path to the logfile, will assume stdin if set to -")
+	fs.StringVar(&o.Launch, "launch", "", "name of the report, defaults to run<time> or to the one of -resume")
+	fs.StringVar(&o.Suite, "name", "", "name of the suite, defaults to run<time> or to the one of -resume")
+	fs.BoolVar(&o.IgnoreErrors, "ignoreErrors", false,
+		"keep going after errors, they are still reported at the end and make the exit code non-zero")
+	fs.StringVar(&o.Format, "format", formatAuto,
+		"format of the log: auto, text, gotest-json (output of go test -json) or junit (junit xml report)")
+	fs.StringVar(&o.GrammarFile, "grammar", "",
+		"yaml or json file with the line grammar of text logs, defaults to the built-in kuttl/argo grammar")
+	fs.BoolVar(&o.FlattenSubtests, "flattenSubtests", false,
+		"report go subtests like TestFoo/case_1 next to TestFoo instead of nested in it")
+	fs.StringVar(&o.AttachmentDir, "attachments", "",
+		"directory of files to attach to the tests they are named after, i.e. kuttl artifacts or must-gather output, "+
+			"the files are not redacted")
+	fs.StringVar(&o.AttachmentPattern, "attachmentPattern", DefaultAttachmentPattern,
+		"regex matched against the paths in the attachments directory, its test group names the test to attach to")
+	fs.StringVar(&o.LevelsFile, "levels", "",
+		"yaml file mapping log levels and messages to the levels of the portal, extending the built-in mapping")
+	fs.StringVar(&o.MinLevel, "minLevel", "",
+		"drop log lines below this level: trace, debug, info, warn, error or fatal")
+	fs.StringVar(&o.StartTime, "startTime", "",
+		"time of the log lines before the first one with a time, defaults to the first time of -startTimeFrom")
+	fs.StringVar(&o.StartTimeFrom, "startTimeFrom", DefaultStartTimeFrom,
+		"where the start time is taken from without -startTime, in order: mtime of the file, "+
+			"the first time in the log, now")
+	fs.StringVar(&o.RedactFile, "redact", "",
+		"yaml file with more patterns of secrets to mask in the log lines, besides passwords, tokens and keys")
+	fs.StringVar(&o.TZ, "tz", "",
+		"time zone of the times in the log without one, i.e. Local or Europe/Prague, defaults to UTC")
+	fs.Var(&o.AttributePatterns, "attrPattern",
+		"regex matched against every line of the log, its named groups become launch attributes, repeatable")
+}
+
+// uploadFlags are the flags of upload, which are all the settings of the config.
+func (o *UploadOptions) uploadFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	o.addParseFlags(fs)
+	o.ConnectionOptions.addFlags(fs)
+	fs.BoolVar(&o.SkipExisting, "skipExisting", false, "skip existing launches")
+	fs.BoolVar(&o.LaunchLogs, "launchLogs", true, "also add every log line to the launch itself")
+	batch := DefaultLogBatchOptions()
+	fs.IntVar(&o.LogBatch.MaxCount, "logBatchCount", batch.MaxCount, "upload log lines in batches of this many")
+	fs.IntVar(&o.LogBatch.MaxBytes, "logBatchBytes", batch.MaxBytes, "upload log lines once they are this big")
+	fs.DurationVar(&o.LogBatch.MaxWait, "logBatchWait", batch.MaxWait,
+		"upload log lines buffered for this long, checked whenever a line of the log is read")
+	fs.IntVar(&o.Concurrency, "concurrency", 1, "how many requests to the portal can be in flight at once")
+	fs.BoolVar(&o.DryRun, "dry-run", false,
+		"only print the launch, suites and tests found in the log instead of uploading them, needs no token")
+	fs.Var(&o.Outputs, "output",
+		"where to report to, repeatable: reportportal (the default), json=file or junit=file")
+	fs.StringVar(&o.Journal, "journal", "",
+		"file to write the progress of the upload to, for -resume to continue it once it is interrupted")
+	fs.StringVar(&o.Resume, "resume", "",
+		"journal of an interrupted upload of a text log, its items are reused and the upload goes on after the last "+
+			"reported line")
+	fs.Var(&o.Attributes, "attr", "attribute of the launch as key:value, repeatable")
+	fs.Var(&o.TestAttributePatterns, "testAttrPattern",
+		`regex matched against test names, its named groups become test attributes, repeatable (default "^(?P<group>\d+)-")`)
+	return fs
+}
+
+func runUpload(args []string) error {
+	o := &UploadOptions{}
+	s, err := parseFlags(o.uploadFlags(), args)
+	if err != nil {
+		return err
+	}
+	if o.needsToken() {
+		if err := o.resolveToken(s); err != nil {
+			return err
+		}
+	}
+	return run(o)
+}
+
+// runParse prints the report found in the log without talking to the portal.
+func runParse(args []string) error {
+	o := &UploadOptions{DryRun: true}
+	fs := flag.NewFlagSet("parse", flag.ExitOnError)
+	o.addParseFlags(fs)
+	fs.BoolVar(&o.PrintJSON, "json", false, "print the report as json instead of a tree")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return run(o)
+}
+
+// runConfig shows the settings upload would run with.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return &InputError{Field: "config command", Value: strings.Join(args, " "), Err: fmt.Errorf("use config show")}
+	}
+	o := &UploadOptions{}
+	fs := o.uploadFlags()
+	s, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	return s.print(os.Stdout, fs)
+}
+
+// connect parses the flags of a command talking to the portal, adding the connection flags to them.
+func connect(fs *flag.FlagSet, args []string) (*ConnectionOptions, *resty.Client, error) {
+	c := &ConnectionOptions{}
+	c.addFlags(fs)
+	s, err := parseFlags(fs, args, connectionFlags...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.resolveToken(s); err != nil {
+		return nil, nil, err
+	}
+	client, err := c.client()
+	return c, client, err
+}
+
+func runLaunches(args []string) error {
+	filter := &LaunchFilter{}
+	fs := flag.NewFlagSet("launches", flag.ExitOnError)
+	fs.StringVar(&filter.Name, "name", "", "only the launches with this in their name")
+	fs.Var(&filter.Attributes, "attr", "only the launches with this key:value attribute, repeatable")
+	fs.IntVar(&filter.Limit, "limit", 20, "how many of the newest launches to list")
+	c, client, err := connect(fs, args)
+	if err != nil {
+		return err
+	}
+	launches, err := searchLaunches(client, c.Project, filter)
+	if err != nil {
+		return err
+	}
+	return printLaunches(os.Stdout, launches)
+}
+
+func runDelete(args []string) error {
+	var ids, names ListFlag
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	fs.Var(&ids, "id", "id of a launch to delete, repeatable")
+	fs.Var(&names, "name", "name of the launch to delete, repeatable")
+	all := fs.Bool("all", false, "delete every launch of a -name, which has to name a single launch otherwise")
+	dryRun := fs.Bool("dry-run", false, "print the launches which would be deleted without deleting them")
+	c, client, err := connect(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ids)+len(names) == 0 {
+		return &InputError{Field: "launch", Err: fmt.Errorf("pass -id or -name of the launches to delete")}
+	}
+	launches, err := findLaunches(client, c.Project, ids, nil)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		named, err := findLaunches(client, c.Project, nil, []string{name})
+		if err != nil {
+			return err
+		}
+		if len(named) > 1 && !*all {
+			found := []string{}
+			for _, l := range named {
+				found = append(found, fmt.Sprintf("#%d (id %s)", l.Number, l.ID))
+			}
+			return &InputError{Field: "launch", Value: name, Err: fmt.Errorf(
+				"%d launches have this name: %s, pass -all to delete all of them or -id to pick one",
+				len(named), strings.Join(found, ", "))}
+		}
+		launches = append(launches, named...)
+	}
+	if *dryRun {
+		for _, l := range launches {
+			fmt.Printf("Would delete launch %s #%d (id %s)\n", l.Name, l.Number, l.ID)
+		}
+		return nil
+	}
+	report := &ErrorReport{noErrors: true}
+	for _, l := range launches {
+		if report.add(deleteLaunch(client, c.Project, l)) {
+			fmt.Printf("Deleted launch %s #%d (id %s)\n", l.Name, l.Number, l.ID)
+		}
+	}
+	return report.err()
+}
+
+func runMerge(args []string) error {
+	var ids, names ListFlag
+	rq := &MergeRequest{}
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Var(&ids, "id", "id of a launch to merge, repeatable")
+	fs.Var(&names, "name", "name of the launches to merge, every launch of the name is merged, repeatable")
+	fs.StringVar(&rq.Name, "into", "", "name of the merged launch")
+	fs.StringVar(&rq.Type, "type", "BASIC",
+		"BASIC keeps the suites of the launches side by side, DEEP merges the suites of the same name")
+	fs.BoolVar(&rq.ExtendSuitesDescription, "describeSuites", false,
+		"note the launch every suite came from in its description")
+	fs.Var((*AttrFlag)(&rq.Attributes), "attr", "attribute of the merged launch as key:value, repeatable")
+	c, client, err := connect(fs, args)
+	if err != nil {
+		return err
+	}
+	rq.Type = strings.ToUpper(rq.Type)
+	if rq.Type != "BASIC" && rq.Type != "DEEP" {
+		return &InputError{Field: "type", Value: rq.Type, Err: fmt.Errorf("use BASIC or DEEP")}
+	}
+	launches, err := findLaunches(client, c.Project, ids, names)
+	if err != nil {
+		return err
+	}
+	if len(launches) < 2 {
+		return &InputError{Field: "launch", Err: fmt.Errorf("found %d launches to merge, it takes at least 2",
+			len(launches))}
+	}
+	if rq.Name == "" {
+		rq.Name = launches[0].Name
+	}
+	for _, l := range launches {
+		rq.Launches = append(rq.Launches, l.ID)
+	}
+	merged, err := mergeLaunches(client, c.Project, rq)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d launches into %s #%d (id %s)\n", len(launches), merged.Name, merged.Number, merged.ID)
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing the commands", func() {
+	var portal *FakePortal
+	var url string
+	var home string
+
+	BeforeEach(func() {
+		portal = NewFakePortal()
+		srv := httptest.NewServer(portal)
+		DeferCleanup(srv.Close)
+		url = srv.URL
+		// no config of the machine running the tests is read
+		home = GinkgoT().TempDir()
+		GinkgoT().Setenv("HOME", home)
+		GinkgoT().Setenv("RP_TOKEN", "TOKEN")
+	})
+
+	upload := func(file, launch string, flags ...string) error {
+		return runCommand(append([]string{"upload", "-url", url, "-project", "TEST_PROJECT",
+			"-file", file, "-launch", launch, "-name", "suite"}, flags...))
+	}
+	launchNames := func() []string {
+		names := []string{}
+		for _, l := range portal.Launches() {
+			names = append(names, l.Name)
+		}
+		return names
+	}
+
+	It("Uploads, lists, merges and deletes launches", func() {
+		Expect(upload("./test_data/parallel-kuttl.txt", "nightly-1", "-attr", "ci:prow")).To(Succeed())
+		Expect(upload("./test_data/go-test-json.log", "nightly-2")).To(Succeed())
+		Expect(upload("./test_data/go-test-json.log", "adhoc")).To(Succeed())
+
+		client := resty.New().SetBaseURL(url).SetAuthToken("TOKEN")
+		launches, err := searchLaunches(client, "TEST_PROJECT", &LaunchFilter{Name: "nightly"})
+		Expect(err).To(BeNil())
+		Expect(launches).To(HaveLen(2))
+		// the newest first, the go test log starts a bit later than the kuttl one
+		Expect(launches[0].Name).To(Equal("nightly-2"))
+		launches, err = searchLaunches(client, "TEST_PROJECT",
+			&LaunchFilter{Attributes: AttrFlag{{Key: "ci", Value: "prow"}}})
+		Expect(err).To(BeNil())
+		Expect(launches).To(HaveLen(1))
+		Expect(launches[0].Name).To(Equal("nightly-1"))
+		Expect(runCommand([]string{"launches", "-url", url, "-project", "TEST_PROJECT", "-name", "nightly"})).To(
+			Succeed())
+
+		Expect(runCommand([]string{"merge", "-url", url, "-project", "TEST_PROJECT",
+			"-name", "nightly-1,nightly-2", "-into", "nightly"})).To(Succeed())
+		Expect(launchNames()).To(Equal([]string{"adhoc", "nightly"}))
+		// the kuttl suite next to the two packages of the go test log
+		Expect(portal.Launches()[1].Children).To(HaveLen(3))
+
+		Expect(runCommand([]string{"delete", "-url", url, "-project", "TEST_PROJECT", "-name", "nightly"})).To(
+			Succeed())
+		Expect(launchNames()).To(Equal([]string{"adhoc"}))
+		Expect(runCommand([]string{"delete", "-url", url, "-project", "TEST_PROJECT", "-name", "nightly"})).To(
+			MatchError(ContainSubstring(`bad launch "nightly": no launch with this name`)))
+		Expect(portal.Problems).To(BeEmpty())
+	})
+
+	It("Deletes the launches of a name only when asked to delete all of them", func() {
+		// an upload goes on with the launch of its name, two launches of a name come from elsewhere
+		for i := 0; i < 2; i++ {
+			lg := NewRPLogger(resty.New().SetBaseURL(url), "TOKEN", "TEST_PROJECT")
+			Expect(lg.EnsureLaunch("nightly", "suite", "2023-11-21T00:17:10Z")).To(Succeed())
+			Expect(lg.Finish("2023-11-21T00:17:11Z")).To(Succeed())
+		}
+		del := func(flags ...string) error {
+			return runCommand(append([]string{"delete", "-url", url, "-project", "TEST_PROJECT", "-name", "nightly"},
+				flags...))
+		}
+		Expect(del()).To(MatchError(ContainSubstring(
+			`bad launch "nightly": 2 launches have this name: #1 (id 1), #2 (id 3), pass -all`)))
+		Expect(del("-all", "-dry-run")).To(Succeed())
+		Expect(launchNames()).To(Equal([]string{"nightly", "nightly"}))
+		Expect(del("-all")).To(Succeed())
+		Expect(launchNames()).To(BeEmpty())
+		Expect(portal.Problems).To(BeEmpty())
+	})
+
+	It("Resumes the launch and suite of the journal without repeating their names", func() {
+		journal := filepath.Join(home, "upload.json")
+		// the upload was interrupted right after it started its launch and suite
+		lg := NewRPLogger(resty.New().SetBaseURL(url), "TOKEN", "TEST_PROJECT")
+		lg.JournalPath = journal
+		Expect(lg.EnsureLaunch("nightly", "kuttl", "2023-11-21T00:17:10Z")).To(Succeed())
+
+		resume := func(flags ...string) error {
+			return runCommand(append([]string{"upload", "-url", url, "-project", "TEST_PROJECT",
+				"-file", "./test_data/parallel-kuttl.txt", "-resume", journal}, flags...))
+		}
+		Expect(resume("-name", "other")).To(MatchError(ContainSubstring(
+			`bad name "other": -resume goes on with suite kuttl`)))
+		Expect(resume("-launch", "adhoc")).To(MatchError(ContainSubstring(
+			`bad launch "adhoc": -resume goes on with launch nightly`)))
+		Expect(resume()).To(Succeed())
+		Expect(launchNames()).To(Equal([]string{"nightly"}))
+		launch := portal.Launches()[0]
+		Expect(launch.Children).To(HaveLen(1))
+		Expect(launch.Find("kuttl").Children).NotTo(BeEmpty())
+		Expect(launch.EndTime).NotTo(BeZero())
+		Expect(portal.Problems).To(BeEmpty())
+	})
+
+	It("Merges the suites of the same name with DEEP", func() {
+		Expect(upload("./test_data/go-test-json.log", "first")).To(Succeed())
+		Expect(upload("./test_data/go-test-json.log", "second")).To(Succeed())
+		tests := len(portal.Launches()[0].Find("github.com/example/calc").Children)
+		ids := []string{}
+		for _, l := range portal.Launches() {
+			ids = append(ids, "-id", strconv.Itoa(l.ID))
+		}
+		Expect(runCommand(append([]string{"merge", "-url", url, "-project", "TEST_PROJECT",
+			"-into", "both", "-type", "deep"}, ids...))).To(Succeed())
+		Expect(launchNames()).To(Equal([]string{"both"}))
+		both := portal.Launches()[0]
+		Expect(both.Children).To(HaveLen(2))
+		Expect(both.Find("github.com/example/calc").Children).To(HaveLen(2 * tests))
+		Expect(both.Find("github.com/example/calc", "TestAdd").launch).To(BeIdenticalTo(both))
+	})
+
+	It("Takes the portal from the config", func() {
+		Expect(runCommand([]string{"upload", "-file", "./test_data/go-test-json.log"})).To(
+			MatchError(ContainSubstring("no portal to talk to, pass -url, set RP_URL or url in the config")))
+		Expect(os.MkdirAll(filepath.Join(home, ".config"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(home, ".config", "log2reportportal.yaml"),
+			[]byte("url: "+url+"\nproject: TEST_PROJECT\n"), 0o644)).To(Succeed())
+		Expect(runCommand([]string{"upload", "-file", "./test_data/go-test-json.log", "-launch", "configured"})).To(
+			Succeed())
+		Expect(launchNames()).To(Equal([]string{"configured"}))
+	})
+
+	It("Starts the launch at -startTime or at the first time of the -startTimeFrom sources", func() {
+		mtime := time.Date(2023, 11, 21, 0, 17, 10, 0, time.UTC)
+		write := func(name, content string) string {
+			path := filepath.Join(home, name)
+			Expect(os.WriteFile(path, []byte(content), 0o666)).To(Succeed())
+			Expect(os.Chtimes(path, mtime, mtime)).To(Succeed())
+			return path
+		}
+		timeless := write("timeless.log", "=== RUN   TestA\n    logged in\n--- PASS: TestA (0.00s)\n")
+		timed := write("timed.log", "=== RUN   TestA\n    time=\"2022-08-02T16:35:54Z\" msg=\"logged in\"\n"+
+			"--- PASS: TestA (0.00s)\n")
+
+		Expect(upload(timed, "flag", "-startTime", "2021-01-02 03:04:05")).To(Succeed())
+		Expect(upload(timed, "modified")).To(Succeed())
+		Expect(upload(timed, "scanned", "-startTimeFrom", "log,mtime")).To(Succeed())
+		Expect(upload(timeless, "timeless", "-startTimeFrom", "log,mtime")).To(Succeed())
+		starts := map[string]string{}
+		for _, l := range portal.Launches() {
+			starts[l.Name] = formatTime(time.UnixMilli(int64(l.StartTime)))
+		}
+		Expect(starts).To(Equal(map[string]string{
+			"flag": "2021-01-02T03:04:05.001Z", "modified": "2023-11-21T00:17:10.001Z",
+			"scanned": "2022-08-02T16:35:54.001Z", "timeless": "2023-11-21T00:17:10.001Z",
+		}))
+
+		Expect(upload(timeless, "nothing", "-startTimeFrom", "log")).To(MatchError(ContainSubstring(
+			`bad start time "log": none of the sources has a time`)))
+		Expect(upload(timed, "bad", "-startTimeFrom", "mtime,yesterday")).To(MatchError(ContainSubstring(
+			`bad start time source "yesterday": use mtime, log or now`)))
+	})
+
+	It("Parses offline", func() {
+		Expect(runCommand([]string{"parse", "-file", "./test_data/go-test-json.log", "-json"})).To(Succeed())
+		Expect(runCommand([]string{"parse", "-file", "./test_data/go-test-json.log"})).To(Succeed())
+		Expect(portal.Launches()).To(BeEmpty())
+	})
+
+	DescribeTable("Printing only the json of parse -json on stdout",
+		func(file string) {
+			stdout, err := os.CreateTemp(home, "stdout")
+			Expect(err).To(BeNil())
+			defer stdout.Close()
+			saved := os.Stdout
+			os.Stdout = stdout
+			err = runCommand([]string{"parse", "-file", file, "-json"})
+			os.Stdout = saved
+			Expect(err).To(BeNil())
+			b, err := os.ReadFile(stdout.Name())
+			Expect(err).To(BeNil())
+			tree := &ReportTree{}
+			Expect(json.Unmarshal(b, tree)).To(Succeed())
+			Expect(tree.Launch.Suites).NotTo(BeEmpty())
+			Expect(tree.Launch.Suites[0].Tests).NotTo(BeEmpty())
+		},
+		Entry("text", "./test_data/parallel-kuttl.txt"),
+		Entry("go test -json", "./test_data/go-test-json.log"),
+		Entry("a log cut before the result of its first test", "./test_data/argocd-e2e-186_last.log"),
+	)
+
+	It("Attaches the relative paths of the log from another working directory", func() {
+		logs, artifacts := filepath.Join(home, "att", "logs"), filepath.Join(home, "att", "artifacts")
+		Expect(os.MkdirAll(logs, 0o755)).To(Succeed())
+		Expect(os.MkdirAll(artifacts, 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(logs, "pods.yaml"), []byte("pods"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(artifacts, "events.txt"), []byte("events"), 0o644)).To(Succeed())
+		log := filepath.Join(logs, "build.log")
+		Expect(os.WriteFile(log, []byte("=== RUN   TestA\n    time=\"2022-08-02T16:35:54Z\"\n    ATTACH: pods.yaml\n"+
+			"    ATTACH: events.txt\n--- PASS: TestA (0.00s)\n"), 0o644)).To(Succeed())
+		wd, err := os.Getwd()
+		Expect(err).To(BeNil())
+		Expect(os.Chdir(home)).To(Succeed())
+		DeferCleanup(os.Chdir, wd)
+
+		stdout, err := os.CreateTemp(home, "stdout")
+		Expect(err).To(BeNil())
+		defer stdout.Close()
+		saved := os.Stdout
+		os.Stdout = stdout
+		err = runCommand([]string{"parse", "-file", log, "-attachments", artifacts, "-json"})
+		os.Stdout = saved
+		Expect(err).To(BeNil())
+		b, err := os.ReadFile(stdout.Name())
+		Expect(err).To(BeNil())
+		tree := &ReportTree{}
+		Expect(json.Unmarshal(b, tree)).To(Succeed())
+		Expect(tree.Launch.Suites[0].Tests[0].Attachments).To(ConsistOf(
+			HaveSuffix("/att/logs/pods.yaml"), HaveSuffix("/att/artifacts/events.txt")))
+	})
+
+	It("Rejects unknown commands", func() {
+		Expect(runCommand([]string{"uplaod"})).To(MatchError(`bad command "uplaod": unknown command`))
+	})
+
+	It("Prints the launches as a table", func() {
+		b := &bytes.Buffer{}
+		Expect(printLaunches(b, []RPLaunch{{ID: "7", Name: "nightly", Number: 3, Status: "FAILED",
+			StartTime: 1700525830883, Attributes: []RPAttribute{{Key: "ci", Value: "prow"}, {Value: "kuttl"}}},
+		})).To(Succeed())
+		Expect(b.String()).To(Equal(
+			"ID  NAME     NUMBER  STATUS  START                     ATTRIBUTES\n" +
+				"7   nightly  3       FAILED  2023-11-21T00:17:10.883Z  ci:prow kuttl\n"))
+	})
+})
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the defaults of the flags keyed by their names, the named profiles override them
+// and profile picks the one used without -profile:
+//
+//	url: https://reportportal.example.com
+//	project: gitops-adhoc
+//	token: ...
+//	profiles:
+//	  nightly:
+//	    project: gitops-nightly
+//	    skipExisting: true
+//	    skipTls: true
+type Config struct {
+	Settings map[string]any            `yaml:",inline"`
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+// defaultConfigPath is ~/.config/log2reportportal.yaml, empty when there is no home directory.
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "log2reportportal.yaml")
+}
+
+// loadConfig reads the config file, there are no defaults when the default one does not exist.
+func loadConfig(path string, required bool) (*Config, error) {
+	c := &Config{}
+	if path == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// envName is the environment variable of a flag, i.e. RP_SKIP_TLS for -skipTls.
+func envName(flagName string) string {
+	b := &strings.Builder{}
+	b.WriteString("RP_")
+	for _, r := range flagName {
+		switch {
+		case r == '-':
+			r = '_'
+		case unicode.IsUpper(r):
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// settingValues turns a yaml value into flag values, a list sets a repeatable flag several times.
+func settingValues(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// presetValue takes the value of a flag in the first pass over the command line.
+type presetValue struct {
+	value  *string
+	isBool bool
+}
+
+func (v presetValue) String() string {
+	return ""
+}
+
+func (v presetValue) Set(value string) error {
+	*v.value = value
+	return nil
+}
+
+func (v presetValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// givenFlags are the flags on the command line with their last values, they are looked up before the flags are parsed
+// to pick the config and to leave the settings alone which the command line overrides anyway.
+func givenFlags(fs *flag.FlagSet, args []string) map[string]string {
+	values := map[string]*string{}
+	first := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	first.SetOutput(io.Discard)
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		values[f.Name] = new(string)
+		first.Var(presetValue{value: values[f.Name], isBool: ok && b.IsBoolFlag()}, f.Name, f.Usage)
+	})
+	// a bad command line is reported by the second pass
+	_ = first.Parse(args)
+	given := map[string]string{}
+	first.Visit(func(f *flag.Flag) {
+		given[f.Name] = *values[f.Name]
+	})
+	return given
+}
+
+// Settings are where the values of the flags of a command came from:
+// the config file, its profile, the environment or the command line, in the order they override each other.
+type Settings struct {
+	Path    string
+	Profile string
+	// Token authenticates to the portal, it has no flag
+	Token   string
+	sources map[string]string
+}
+
+// configLayer are the settings of the config file or of one of its profiles.
+type configLayer struct {
+	source string
+	values map[string]any
+}
+
+// parseFlags parses the flags of a command on top of the config and the environment,
+// only the named flags are taken from them when there are any.
+func parseFlags(fs *flag.FlagSet, args []string, only ...string) (*Settings, error) {
+	s := &Settings{sources: map[string]string{}}
+	fs.StringVar(&s.Path, "config", defaultConfigPath(), "yaml file with the defaults of the flags")
+	fs.StringVar(&s.Profile, "profile", "", "profile of the config file overriding its defaults, i.e. nightly")
+	given := givenFlags(fs, args)
+	preset := func(name, def string) (string, bool) {
+		if value, ok := given[name]; ok {
+			return value, true
+		}
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			return value, true
+		}
+		return def, false
+	}
+	path, required := preset("config", defaultConfigPath())
+	cfg, err := loadConfig(path, required)
+	if err != nil {
+		return nil, err
+	}
+	profile, _ := preset("profile", strings.Join(settingValues(cfg.Settings["profile"]), ""))
+	layers := []configLayer{{"config", cfg.Settings}}
+	if profile != "" {
+		values, ok := cfg.Profiles[profile]
+		if !ok {
+			return nil, &InputError{Field: "profile", Value: profile, Err: fmt.Errorf("not found in %s", path)}
+		}
+		layers = append(layers, configLayer{"profile " + profile, values})
+	}
+
+	taken := func(name string) bool {
+		if _, ok := given[name]; ok || name == "config" || name == "profile" {
+			return false
+		}
+		for _, o := range only {
+			if o == name {
+				return true
+			}
+		}
+		return len(only) == 0
+	}
+	values := map[string][]string{}
+	for _, layer := range layers {
+		for name, v := range layer.values {
+			if name == "token" {
+				s.Token, s.sources[name] = strings.Join(settingValues(v), ""), layer.source
+				continue
+			}
+			if fs.Lookup(name) != nil && taken(name) {
+				values[name], s.sources[name] = settingValues(v), layer.source
+			}
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && taken(f.Name) {
+			values[f.Name], s.sources[f.Name] = []string{v}, "env "+envName(f.Name)
+		}
+	})
+	if token, ok := os.LookupEnv("RP_TOKEN"); ok {
+		s.Token, s.sources["token"] = token, "env RP_TOKEN"
+	}
+	for name, vs := range values {
+		for _, v := range vs {
+			if err := fs.Set(name, v); err != nil {
+				return nil, &InputError{Field: name, Value: v, Err: fmt.Errorf("from %s: %w", s.sources[name], err)}
+			}
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	for name := range given {
+		s.sources[name] = "flag"
+	}
+	s.Path, s.Profile = path, profile
+	return s, nil
+}
+
+// maskToken hides all of the token but its end, which tells the tokens apart.
+func maskToken(token string) string {
+	if len(token) < 12 {
+		return strings.Repeat("*", len(token))
+	}
+	return strings.Repeat("*", 8) + token[len(token)-4:]
+}
+
+// print writes the effective value of every flag together with where it came from.
+func (s *Settings) print(w io.Writer, fs *flag.FlagSet) error {
+	fmt.Fprintf(w, "config: %s\n", s.Path)
+	if s.Profile != "" {
+		fmt.Fprintf(w, "profile: %s\n", s.Profile)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tFROM")
+	names := []string{"token"}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "profile" {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		source := s.sources[name]
+		if source == "" {
+			source = "default"
+		}
+		value := maskToken(s.Token)
+		if name != "token" {
+			value = fs.Lookup(name).Value.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, source)
+	}
+	return tw.Flush()
+}
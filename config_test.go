@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing the config", func() {
+	var path string
+
+	// unsetenv clears a variable for the spec only
+	unsetenv := func(key string) {
+		if value, ok := os.LookupEnv(key); ok {
+			Expect(os.Unsetenv(key)).To(Succeed())
+			DeferCleanup(os.Setenv, key, value)
+		}
+	}
+
+	BeforeEach(func() {
+		GinkgoT().Setenv("HOME", GinkgoT().TempDir())
+		unsetenv("RP_TOKEN")
+		path = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(`
+url: https://portal.example.com
+project: gitops-adhoc
+token: adhoc-token-abcd
+retries: 1
+profiles:
+  nightly:
+    project: gitops-nightly
+    skipExisting: true
+    skipTls: true
+    attr: [ci:prow, nightly]
+`), 0o644)).To(Succeed())
+	})
+
+	DescribeTable("Naming the environment variables after the flags", func(flagName, env string) {
+		Expect(envName(flagName)).To(Equal(env))
+	},
+		Entry("single word", "url", "RP_URL"),
+		Entry("camel case", "logBatchCount", "RP_LOG_BATCH_COUNT"),
+		Entry("dashes", "dry-run", "RP_DRY_RUN"),
+	)
+
+	It("Overrides the config by its profile, the environment and the flags", func() {
+		GinkgoT().Setenv("RP_RETRIES", "5")
+		o := &UploadOptions{}
+		s, err := parseFlags(o.uploadFlags(), []string{"-config", path, "-profile", "nightly", "-project", "mine"})
+		Expect(err).To(BeNil())
+		Expect(o.PortalURL).To(Equal("https://portal.example.com"))
+		Expect(o.Project).To(Equal("mine"))
+		Expect(o.SkipTLS).To(BeTrue())
+		Expect(o.SkipExisting).To(BeTrue())
+		Expect(o.Retries).To(Equal(5))
+		Expect(o.Attributes).To(Equal(AttrFlag{{Key: "ci", Value: "prow"}, {Value: "nightly"}}))
+		Expect(s.Token).To(Equal("adhoc-token-abcd"))
+
+	})
+
+	It("Shows the settings in effect with the token masked", func() {
+		GinkgoT().Setenv("RP_RETRIES", "5")
+		fs := (&UploadOptions{}).uploadFlags()
+		s, err := parseFlags(fs, []string{"-config", path, "-profile", "nightly", "-project", "mine"})
+		Expect(err).To(BeNil())
+		b := &bytes.Buffer{}
+		Expect(s.print(b, fs)).To(Succeed())
+		Expect(b.String()).To(HavePrefix("config: " + path + "\nprofile: nightly\n"))
+		Expect(b.String()).To(MatchRegexp(`(?m)^token\s+\*{8}abcd\s+config$`))
+		Expect(b.String()).To(MatchRegexp(`(?m)^url\s+https://portal.example.com\s+config$`))
+		Expect(b.String()).To(MatchRegexp(`(?m)^skipTls\s+true\s+profile nightly$`))
+		Expect(b.String()).To(MatchRegexp(`(?m)^retries\s+5\s+env RP_RETRIES$`))
+		Expect(b.String()).To(MatchRegexp(`(?m)^project\s+mine\s+flag$`))
+		Expect(b.String()).To(MatchRegexp(`(?m)^concurrency\s+1\s+default$`))
+		Expect(b.String()).NotTo(ContainSubstring("adhoc-token"))
+	})
+
+	It("Takes the token and the profile from the environment", func() {
+		GinkgoT().Setenv("RP_CONFIG", path)
+		GinkgoT().Setenv("RP_PROFILE", "nightly")
+		GinkgoT().Setenv("RP_TOKEN", "env-token")
+		o := &UploadOptions{}
+		s, err := parseFlags(o.uploadFlags(), nil)
+		Expect(err).To(BeNil())
+		Expect(o.Project).To(Equal("gitops-nightly"))
+		Expect(s.Token).To(Equal("env-token"))
+		Expect(s.Profile).To(Equal("nightly"))
+	})
+
+	It("Gives the commands reading the portal only the connection settings", func() {
+		GinkgoT().Setenv("RP_NAME", "suite")
+		var name string
+		fs := flag.NewFlagSet("launches", flag.ContinueOnError)
+		fs.StringVar(&name, "name", "", "")
+		c := &ConnectionOptions{}
+		c.addFlags(fs)
+		_, err := parseFlags(fs, []string{"-config", path}, connectionFlags...)
+		Expect(err).To(BeNil())
+		Expect(name).To(BeEmpty())
+		Expect(c.Project).To(Equal("gitops-adhoc"))
+	})
+
+	It("Reads the example config", func() {
+		o := &UploadOptions{}
+		_, err := parseFlags(o.uploadFlags(), []string{"-config", "config.example.yaml", "-profile", "nightly"})
+		Expect(err).To(BeNil())
+		Expect(o.PortalURL).NotTo(BeEmpty())
+		Expect(o.Project).To(Equal("gitops-nightly"))
+		Expect(o.SkipExisting).To(BeTrue())
+		Expect(o.SkipTLS).To(BeTrue())
+
+		// only the nightly runs skip the verification of the certificate
+		o = &UploadOptions{}
+		_, err = parseFlags(o.uploadFlags(), []string{"-config", "config.example.yaml"})
+		Expect(err).To(BeNil())
+		Expect(o.Project).To(Equal("gitops-adhoc"))
+		Expect(o.SkipTLS).To(BeFalse())
+	})
+
+	It("Rejects unknown profiles, bad values and missing files", func() {
+		_, err := parseFlags((&UploadOptions{}).uploadFlags(), []string{"-config", path, "-profile", "weekly"})
+		Expect(err).To(MatchError(ContainSubstring(`bad profile "weekly": not found in ` + path)))
+		GinkgoT().Setenv("RP_CONCURRENCY", "many")
+		_, err = parseFlags((&UploadOptions{}).uploadFlags(), []string{"-config", path})
+		Expect(err).To(MatchError(ContainSubstring(`bad concurrency "many": from env RP_CONCURRENCY`)))
+		_, err = parseFlags((&UploadOptions{}).uploadFlags(), []string{"-config", path + ".missing"})
+		Expect(err).To(MatchError(ContainSubstring("reading config")))
+	})
+})
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// errNoLaunch is returned when a test is reported before there was a timestamp to start the launch with.
+	errNoLaunch = errors.New("no launch has been started")
+	// errNoID is returned when the portal accepted a new item but did not tell its id.
+	errNoID = errors.New("response has no id")
+)
+
+// PortalError is returned when ReportPortal could not be reached or rejected a request.
+type PortalError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	// ErrorCode and Message are decoded from the error body of ReportPortal
+	ErrorCode int
+	Message   string
+	// Item is the name of the launch, suite or test being reported
+	Item string
+	Err  error
+}
+
+func (e *PortalError) Error() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s", e.Method, e.Path)
+	if e.Item != "" {
+		fmt.Fprintf(b, " (%s)", e.Item)
+	}
+	if e.Status != "" {
+		fmt.Fprintf(b, ": %s", e.Status)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(b, ": error %d: %s", e.ErrorCode, e.Message)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *PortalError) Unwrap() error {
+	return e.Err
+}
+
+// withItem records the name of the reported item on portal errors.
+func withItem(name string, err error) error {
+	var pe *PortalError
+	if errors.As(err, &pe) && pe.Item == "" {
+		pe.Item = name
+	}
+	return err
+}
+
+// InputError is returned when a value taken from the log can not be used, i.e. a malformed timestamp.
+type InputError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *InputError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("bad %s: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("bad %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// LineError ties an error to the line of the input it was caused by.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorReport collects the errors of a single run.
+type ErrorReport struct {
+	Errors []error
+	// noErrors keeps the processing going after an error
+	noErrors bool
+}
+
+// add records err, returning whether the processing should go on.
+func (r *ErrorReport) add(err error) bool {
+	if err == nil {
+		return true
+	}
+	r.Errors = append(r.Errors, err)
+	return r.noErrors
+}
+
+// addLine records the errors of the line of the input, the actions of a block can fail for several of its lines.
+func (r *ErrorReport) addLine(line int, err error) bool {
+	var lines *ErrorReport
+	if !errors.As(err, &lines) {
+		return r.add(&LineError{Line: line, Err: err})
+	}
+	for _, e := range lines.Errors {
+		r.add(&LineError{Line: line, Err: e})
+	}
+	return r.noErrors
+}
+
+// err returns the report as an error, or nil when nothing went wrong.
+func (r *ErrorReport) err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return r
+}
+
+func (r *ErrorReport) Error() string {
+	portal, input := 0, 0
+	for _, err := range r.Errors {
+		var pe *PortalError
+		var ie *InputError
+		switch {
+		case errors.As(err, &pe):
+			portal++
+		case errors.As(err, &ie):
+			input++
+		}
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%d error(s) while reporting: %d rejected by the portal, %d in the input, %d other",
+		len(r.Errors), portal, input, len(r.Errors)-portal-input)
+	for _, err := range r.Errors {
+		fmt.Fprintf(b, "\n  %v", err)
+	}
+	return b.String()
+}
+
+// mergeErrors puts the errors of the upload workers in front of the ones of the parsing,
+// leaving out the calls refused because the workers had already stopped.
+func mergeErrors(workerErrors []error, err error) error {
+	report := &ErrorReport{Errors: workerErrors}
+	var other *ErrorReport
+	if errors.As(err, &other) {
+		for _, e := range other.Errors {
+			if !errors.Is(e, errStopped) {
+				report.add(e)
+			}
+		}
+	} else if err != nil && !errors.Is(err, errStopped) {
+		report.add(err)
+	}
+	return report.err()
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakePortal is an in-memory ReportPortal implementing the launch, item and log endpoints used by RPLogger.
+// It keeps the reported tree and, like the real portal, rejects children starting before their parent,
+// on top of that it rejects parents finishing before their children.
+type FakePortal struct {
+	mu       sync.Mutex
+	launches []*FakeItem
+	byUUID   map[string]*FakeItem
+	byID     map[string]*FakeItem
+	lastID   int
+	// Problems are the rejected requests, the reason first
+	Problems []string
+}
+
+// FakeItem is a launch, suite, test or step as kept by the FakePortal.
+type FakeItem struct {
+	ID         int           `json:"id"`
+	UUID       string        `json:"uuid"`
+	Name       string        `json:"name"`
+	Number     int           `json:"number,omitempty"`
+	Type       string        `json:"type"`
+	StartTime  int           `json:"startTime"`
+	EndTime    int           `json:"endTime,omitempty"`
+	Status     string        `json:"status,omitempty"`
+	Attributes []RPAttribute `json:"attributes,omitempty"`
+	Children   []*FakeItem   `json:"children,omitempty"`
+	Logs       []*RPLog      `json:"logs,omitempty"`
+	parent     *FakeItem
+	launch     *FakeItem
+}
+
+func (i *FakeItem) finished() bool {
+	return i.EndTime != 0
+}
+
+// Find looks up a child by its path of names, i.e. Find("TestSuite", "TestFoo", "case_1").
+func (i *FakeItem) Find(names ...string) *FakeItem {
+	item := i
+	for _, name := range names {
+		var next *FakeItem
+		for _, c := range item.Children {
+			if c.Name == name {
+				next = c
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		item = next
+	}
+	return item
+}
+
+func NewFakePortal() *FakePortal {
+	return &FakePortal{byUUID: map[string]*FakeItem{}, byID: map[string]*FakeItem{}}
+}
+
+// Launches is the reported tree, it is not to be read while uploading.
+func (f *FakePortal) Launches() []*FakeItem {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.launches
+}
+
+// Unfinished are the names of the items which were started and never finished.
+func (f *FakePortal) Unfinished() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := []string{}
+	var walk func(items []*FakeItem)
+	walk = func(items []*FakeItem) {
+		for _, i := range items {
+			if !i.finished() {
+				names = append(names, i.Name)
+			}
+			walk(i.Children)
+		}
+	}
+	walk(f.launches)
+	return names
+}
+
+// fakeError is a rejected request, with the status and the error body of the portal.
+type fakeError struct {
+	status int
+	body   RPErrorBody
+}
+
+func (e *fakeError) Error() string {
+	return e.body.Message
+}
+
+func rejected(status, code int, format string, a ...any) *fakeError {
+	return &fakeError{status: status, body: RPErrorBody{ErrorCode: code, Message: fmt.Sprintf(format, a...)}}
+}
+
+var reFakePath = regexp.MustCompile(`^/api/v[12]/[^/]+/(launch|item|log)(?:/([^/]+))?(?:/(finish))?$`)
+
+func (f *FakePortal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		writeFake(w, http.StatusUnauthorized, RPErrorBody{ErrorCode: 40102, Message: "Full authentication is required"})
+		return
+	}
+	m := reFakePath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		writeFake(w, http.StatusNotFound, RPErrorBody{ErrorCode: 40401, Message: "no endpoint " + r.URL.Path})
+		return
+	}
+	kind, id, finish := m[1], m[2], m[3] != ""
+	f.mu.Lock()
+	status, body, err := f.handle(r, kind, id, finish)
+	var fe *fakeError
+	if errors.As(err, &fe) {
+		f.Problems = append(f.Problems, fmt.Sprintf("%s: %s %s", fe.body.Message, r.Method, r.URL.Path))
+		status, body = fe.status, fe.body
+	}
+	f.mu.Unlock()
+	writeFake(w, status, body)
+}
+
+func writeFake(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (f *FakePortal) handle(r *http.Request, kind, id string, finish bool) (int, any, error) {
+	switch {
+	case kind == "log" && r.Method == http.MethodPost:
+		return f.addLogs(r)
+	case r.Method == http.MethodGet && id == "":
+		return f.search(r, kind)
+	case r.Method == http.MethodGet:
+		item := f.lookup(id)
+		if item == nil || (kind == "launch") != (item.Type == "launch") {
+			return 0, nil, rejected(http.StatusNotFound, 40422, "%s '%s' not found", kind, id)
+		}
+		return http.StatusOK, item, nil
+	case kind == "launch" && id == "merge" && r.Method == http.MethodPost:
+		return f.merge(r)
+	case kind == "launch" && id != "" && r.Method == http.MethodDelete:
+		return f.delete(id)
+	case r.Method == http.MethodPost && (kind == "launch" || kind == "item"):
+		return f.start(r, kind, id)
+	case r.Method == http.MethodPut && (kind == "item" || (kind == "launch" && finish)):
+		return f.finish(r, id)
+	}
+	return 0, nil, rejected(http.StatusMethodNotAllowed, 40501, "%s not supported", r.Method)
+}
+
+func (f *FakePortal) lookup(id string) *FakeItem {
+	if item, ok := f.byUUID[id]; ok {
+		return item
+	}
+	return f.byID[id]
+}
+
+func (f *FakePortal) start(r *http.Request, kind, parentID string) (int, any, error) {
+	rq := &RPItem{}
+	if err := json.NewDecoder(r.Body).Decode(rq); err != nil {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "bad body: %v", err)
+	}
+	if rq.UUID == "" {
+		uuid, err := randomUUID()
+		if err != nil {
+			return 0, nil, rejected(http.StatusInternalServerError, 50000, "%v", err)
+		}
+		rq.UUID = uuid
+	}
+	if f.byUUID[rq.UUID] != nil {
+		return 0, nil, rejected(http.StatusConflict, 40901, "item with uuid '%s' already exists", rq.UUID)
+	}
+	item := &FakeItem{UUID: rq.UUID, Name: rq.Name, Type: rq.Type, StartTime: rq.StartTime, Attributes: rq.Attributes}
+	if kind == "launch" {
+		item.Type = "launch"
+		f.addLaunch(item)
+	} else {
+		var parent *FakeItem
+		if parentID != "" {
+			if parent = f.byUUID[parentID]; parent == nil {
+				return 0, nil, rejected(http.StatusNotFound, 40422, "parent item '%s' not found", parentID)
+			}
+		} else if parent = f.byUUID[rq.LaunchUUID]; parent == nil || parent.Type != "launch" {
+			return 0, nil, rejected(http.StatusNotFound, 40422, "launch '%s' not found", rq.LaunchUUID)
+		}
+		if parent.finished() {
+			return 0, nil, rejected(http.StatusBadRequest, 40001, "%s '%s' is already finished", parent.Type, parent.Name)
+		}
+		if item.StartTime < parent.StartTime {
+			return 0, nil, rejected(http.StatusBadRequest, 40001,
+				"start time of child '%s' %d is before the start time of its parent '%s' %d",
+				item.Name, item.StartTime, parent.Name, parent.StartTime)
+		}
+		item.parent, item.launch = parent, parent.launch
+		if parent.Type == "launch" {
+			item.launch = parent
+		}
+		parent.Children = append(parent.Children, item)
+		f.register(item)
+	}
+	return http.StatusCreated, map[string]string{"id": item.UUID}, nil
+}
+
+func (f *FakePortal) register(item *FakeItem) {
+	f.lastID++
+	item.ID = f.lastID
+	f.byUUID[item.UUID] = item
+	f.byID[strconv.Itoa(item.ID)] = item
+}
+
+// addLaunch registers a launch, numbering it among the launches of the same name.
+func (f *FakePortal) addLaunch(launch *FakeItem) {
+	launch.Number = 1
+	for _, l := range f.launches {
+		if l.Name == launch.Name && l.Number >= launch.Number {
+			launch.Number = l.Number + 1
+		}
+	}
+	f.launches = append(f.launches, launch)
+	f.register(launch)
+}
+
+// removeLaunch forgets a launch, together with its items unless they are kept elsewhere.
+func (f *FakePortal) removeLaunch(launch *FakeItem, withItems bool) {
+	for i, l := range f.launches {
+		if l == launch {
+			f.launches = append(f.launches[:i:i], f.launches[i+1:]...)
+			break
+		}
+	}
+	var forget func(item *FakeItem)
+	forget = func(item *FakeItem) {
+		delete(f.byUUID, item.UUID)
+		delete(f.byID, strconv.Itoa(item.ID))
+		for _, c := range item.Children {
+			if withItems {
+				forget(c)
+			}
+		}
+	}
+	forget(launch)
+}
+
+// finishedLaunch looks up a launch which can be deleted or merged.
+func (f *FakePortal) finishedLaunch(id string) (*FakeItem, error) {
+	launch := f.lookup(id)
+	if launch == nil || launch.Type != "launch" {
+		return nil, rejected(http.StatusNotFound, 40422, "launch '%s' not found", id)
+	}
+	if !launch.finished() {
+		return nil, rejected(http.StatusBadRequest, 40001, "launch '%s' is in progress", launch.Name)
+	}
+	return launch, nil
+}
+
+func (f *FakePortal) delete(id string) (int, any, error) {
+	launch, err := f.finishedLaunch(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	f.removeLaunch(launch, true)
+	return http.StatusOK, map[string]string{"message": fmt.Sprintf("Launch with ID = '%s' successfully deleted.", id)}, nil
+}
+
+// merge replaces the launches by a new one, DEEP merges the suites of the same name as well.
+func (f *FakePortal) merge(r *http.Request) (int, any, error) {
+	rq := &MergeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(rq); err != nil {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "bad body: %v", err)
+	}
+	if rq.Name == "" || len(rq.Launches) == 0 {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "the name and the launches to merge are required")
+	}
+	launches := []*FakeItem{}
+	for _, id := range rq.Launches {
+		launch, err := f.finishedLaunch(id.String())
+		if err != nil {
+			return 0, nil, err
+		}
+		launches = append(launches, launch)
+	}
+	uuid, err := randomUUID()
+	if err != nil {
+		return 0, nil, rejected(http.StatusInternalServerError, 50000, "%v", err)
+	}
+	merged := &FakeItem{UUID: uuid, Name: rq.Name, Type: "launch", Attributes: rq.Attributes,
+		StartTime: launches[0].StartTime, EndTime: launches[0].EndTime}
+	for _, launch := range launches {
+		if launch.StartTime < merged.StartTime {
+			merged.StartTime = launch.StartTime
+		}
+		if launch.EndTime > merged.EndTime {
+			merged.EndTime = launch.EndTime
+		}
+		for _, suite := range launch.Children {
+			if same := merged.Find(suite.Name); rq.Type == "DEEP" && same != nil {
+				same.Children = append(same.Children, suite.Children...)
+				if suite.EndTime > same.EndTime {
+					same.EndTime = suite.EndTime
+				}
+				continue
+			}
+			merged.Children = append(merged.Children, suite)
+		}
+		f.removeLaunch(launch, false)
+	}
+	var adopt func(parent *FakeItem)
+	adopt = func(parent *FakeItem) {
+		for _, c := range parent.Children {
+			c.parent, c.launch = parent, merged
+			adopt(c)
+		}
+	}
+	adopt(merged)
+	f.addLaunch(merged)
+	return http.StatusOK, merged, nil
+}
+
+func (f *FakePortal) finish(r *http.Request, id string) (int, any, error) {
+	rq := &struct {
+		EndTime    int           `json:"endTime"`
+		Status     string        `json:"status"`
+		Attributes []RPAttribute `json:"attributes"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(rq); err != nil {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "bad body: %v", err)
+	}
+	item := f.byUUID[id]
+	if item == nil {
+		return 0, nil, rejected(http.StatusNotFound, 40422, "item '%s' not found", id)
+	}
+	if item.finished() {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "%s '%s' is already finished", item.Type, item.Name)
+	}
+	if rq.EndTime < item.StartTime {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "end time of '%s' %d is before its start time %d",
+			item.Name, rq.EndTime, item.StartTime)
+	}
+	for _, c := range item.Children {
+		if !c.finished() {
+			return 0, nil, rejected(http.StatusBadRequest, 40001, "child '%s' of '%s' is not finished", c.Name, item.Name)
+		}
+		if c.EndTime > rq.EndTime {
+			return 0, nil, rejected(http.StatusBadRequest, 40001,
+				"end time of child '%s' %d is after the end time of its parent '%s' %d",
+				c.Name, c.EndTime, item.Name, rq.EndTime)
+		}
+	}
+	item.EndTime, item.Status = rq.EndTime, rq.Status
+	item.Attributes = mergeAttributes(item.Attributes, rq.Attributes...)
+	return http.StatusOK, map[string]string{"message": fmt.Sprintf("%s '%s' finished", item.Type, item.UUID)}, nil
+}
+
+// search answers the name filters of the launch and item lists.
+func (f *FakePortal) search(r *http.Request, kind string) (int, any, error) {
+	q := r.URL.Query()
+	items := f.launches
+	if kind == "item" {
+		items = nil
+		if launch := f.lookup(q.Get("filter.eq.launchId")); launch != nil {
+			items = launch.Children
+		}
+	}
+	content := []*FakeItem{}
+	for _, i := range items {
+		if name := q.Get("filter.eq.name"); name != "" && i.Name != name {
+			continue
+		}
+		if !strings.Contains(i.Name, q.Get("filter.cnt.name")) {
+			continue
+		}
+		if !i.hasAttributes(q.Get("filter.has.compositeAttribute")) {
+			continue
+		}
+		content = append(content, i)
+	}
+	if q.Get("page.sort") == "startTime,DESC" {
+		sort.SliceStable(content, func(a, b int) bool { return content[a].StartTime > content[b].StartTime })
+	}
+	if size, err := strconv.Atoi(q.Get("page.size")); err == nil && size < len(content) {
+		content = content[:size]
+	}
+	return http.StatusOK, map[string]any{"content": content}, nil
+}
+
+// hasAttributes tells whether the item has all of the comma separated key:value or value attributes.
+func (i *FakeItem) hasAttributes(list string) bool {
+	if list == "" {
+		return true
+	}
+	has := map[string]bool{}
+	for _, a := range i.Attributes {
+		has[attrList([]RPAttribute{a}, "")] = true
+	}
+	for _, a := range strings.Split(list, ",") {
+		if !has[a] {
+			return false
+		}
+	}
+	return true
+}
+
+// addLogs takes the logs of a multipart batch, the attached files are only read.
+func (f *FakePortal) addLogs(r *http.Request) (int, any, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return 0, nil, rejected(http.StatusBadRequest, 40001, "bad content type: %v", err)
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	logs := []*RPLog{}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, nil, rejected(http.StatusBadRequest, 40001, "bad multipart body: %v", err)
+		}
+		if part.FormName() == "json_request_part" {
+			if err := json.NewDecoder(part).Decode(&logs); err != nil {
+				return 0, nil, rejected(http.StatusBadRequest, 40001, "bad logs: %v", err)
+			}
+			continue
+		}
+		if _, err := io.Copy(io.Discard, part); err != nil {
+			return 0, nil, rejected(http.StatusBadRequest, 40001, "bad file: %v", err)
+		}
+	}
+	// the whole batch is rejected when any of its logs is, nothing is kept of it
+	items := make([]*FakeItem, len(logs))
+	for i, l := range logs {
+		launch := f.byUUID[l.LaunchUUID]
+		if launch == nil || launch.Type != "launch" {
+			return 0, nil, rejected(http.StatusNotFound, 40422, "launch '%s' of log '%s' not found",
+				l.LaunchUUID, l.Message)
+		}
+		item := launch
+		if l.ItemUUID != "" {
+			if item = f.byUUID[l.ItemUUID]; item == nil || item.launch != launch {
+				return 0, nil, rejected(http.StatusNotFound, 40422, "item '%s' of log '%s' not found",
+					l.ItemUUID, l.Message)
+			}
+		}
+		if item.finished() {
+			return 0, nil, rejected(http.StatusBadRequest, 40001, "%s '%s' of log '%s' is already finished",
+				item.Type, item.Name, l.Message)
+		}
+		items[i] = item
+	}
+	responses := []map[string]string{}
+	for i, l := range logs {
+		items[i].Logs = append(items[i].Logs, l)
+		responses = append(responses, map[string]string{"id": l.UUID})
+	}
+	return http.StatusCreated, map[string]any{"responses": responses}, nil
+}
+
+// runFakeServer serves a FakePortal until killed, GET /tree shows what was reported so far.
+func runFakeServer(args []string) error {
+	fs := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	portal := NewFakePortal()
+	mux := http.NewServeMux()
+	mux.Handle("/api/", portal)
+	mux.HandleFunc("/tree", func(w http.ResponseWriter, r *http.Request) {
+		portal.mu.Lock()
+		defer portal.mu.Unlock()
+		writeFake(w, http.StatusOK, map[string]any{"launches": portal.launches, "problems": portal.Problems})
+	})
+	fmt.Printf("Fake portal listening on http://%s, upload with -url http://%s -project any\n", *addr, *addr)
+	return http.ListenAndServe(*addr, mux)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http/httptest"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/go-resty/resty/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing with the fake portal", func() {
+	var portal *FakePortal
+	var lg *RPLogger
+
+	BeforeEach(func() {
+		portal = NewFakePortal()
+		srv := httptest.NewServer(portal)
+		DeferCleanup(srv.Close)
+		lg = NewRPLogger(resty.New().SetBaseURL(srv.URL), "TOKEN", "TEST_PROJECT")
+	})
+
+	names := func(items []*FakeItem) []string {
+		n := []string{}
+		for _, i := range items {
+			n = append(n, i.Name)
+		}
+		return n
+	}
+
+	It("Keeps the tree of a kuttl upload", func() {
+		Expect(process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{})).To(Succeed())
+		Expect(portal.Problems).To(BeEmpty())
+		Expect(portal.Unfinished()).To(BeEmpty())
+		Expect(portal.Launches()).To(HaveLen(1))
+		launch := portal.Launches()[0]
+		Expect(launch.Name).To(Equal("REPORT_NAME"))
+		Expect(names(launch.Children)).To(Equal([]string{"REPORT_SUITE"}))
+		test := launch.Find("REPORT_SUITE", "1-055_validate_notification_controller")
+		Expect(test).NotTo(BeNil())
+		Expect(test.Status).To(Equal("passed"))
+		Expect(names(test.Children)).To(Equal(
+			[]string{"1-install", "2-enable_notification", "3-disable_notification", "4-check"}))
+		Expect(test.Children[0].Logs).NotTo(BeEmpty())
+		Expect(launch.Logs).NotTo(BeEmpty())
+	})
+
+	It("Nests go subtests in their parent", func() {
+		Expect(process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/go-test-json-nested.log"),
+			&ParseOptions{})).To(Succeed())
+		Expect(portal.Problems).To(BeEmpty())
+		Expect(portal.Unfinished()).To(BeEmpty())
+		for _, suite := range portal.Launches()[0].Children {
+			for _, test := range suite.Children {
+				for _, sub := range test.Children {
+					Expect(sub.Type).To(Equal("step"))
+					Expect(sub.StartTime).To(BeNumerically(">=", test.StartTime))
+					Expect(sub.EndTime).To(BeNumerically("<=", test.EndTime))
+				}
+			}
+		}
+	})
+
+	It("Finishes the subtests printed after their parent first", func() {
+		log := "=== RUN   TestParse\n=== RUN   TestParse/case_1\n    parse_test.go:10: parsing case 1\n" +
+			"=== RUN   TestParse/case_2\n    parse_test.go:10: parsing case 2\n" +
+			"--- FAIL: TestParse (0.20s)\n    --- PASS: TestParse/case_1 (0.10s)\n    --- FAIL: TestParse/case_2 (0.10s)\n" +
+			"FAIL\n"
+		Expect(process(lg, "REPORT_NAME", "REPORT_SUITE", script.Echo(log),
+			&ParseOptions{StartTime: time.Date(2023, 11, 21, 0, 17, 10, 0, time.UTC)})).To(Succeed())
+		Expect(portal.Problems).To(BeEmpty())
+		Expect(portal.Unfinished()).To(BeEmpty())
+		test := portal.Launches()[0].Find("REPORT_SUITE", "TestParse")
+		Expect(test.Status).To(Equal("failed"))
+		Expect(names(test.Children)).To(Equal([]string{"case_1", "case_2"}))
+		Expect(test.Children[0].Status).To(Equal("passed"))
+		Expect(test.Children[1].Status).To(Equal("failed"))
+	})
+
+	It("Rejects children starting before their parent", func() {
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.EnsureTest("TestEarly", "2023-11-21T00:17:09Z")).To(MatchError(
+			ContainSubstring("start time of child 'TestEarly' 1700525829000 is before the start time of its parent")))
+		Expect(portal.Problems).To(HaveLen(1))
+	})
+
+	It("Rejects parents finishing before their children", func() {
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.EnsureStep("1-001_install", "1-install", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.FinnishStep("1-001_install", "1-install", "2023-11-21T00:17:20Z", "PASS")).To(Succeed())
+		Expect(lg.FinnishTest("1-001_install", "2023-11-21T00:17:10Z", "PASS", "5")).To(MatchError(
+			ContainSubstring("end time of child '1-install' 1700525840000 is after the end time of its parent")))
+		Expect(portal.Unfinished()).To(ConsistOf("REPORT_NAME", "REPORT_SUITE", "1-001_install"))
+	})
+
+	It("Rejects logs of finished or unknown items", func() {
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.FinnishTest("TestDone", "2023-11-21T00:17:11Z", "PASS", "1")).To(Succeed())
+		test := lg.testItem("TestDone")
+		send := func(item, message string) error {
+			lg.mu.Lock()
+			lg.batch.add(&RPLog{LaunchUUID: lg.launch.UUID, ItemUUID: item, Time: "2023-11-21T00:17:12Z",
+				Message: message, Level: "info", UUID: message})
+			lg.mu.Unlock()
+			return lg.flushLogs()
+		}
+		Expect(send(test.UUID, "late")).To(MatchError(ContainSubstring("test 'TestDone' of log 'late' is already finished")))
+		Expect(send("missing", "lost")).To(MatchError(ContainSubstring("item 'missing' of log 'lost' not found")))
+		Expect(portal.Problems).To(HaveLen(2))
+		Expect(portal.Launches()[0].Find("REPORT_SUITE", "TestDone").Logs).To(BeEmpty())
+	})
+
+	It("Logs the lines after a finished test to the launch", func() {
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.AddLine("TestDone", "2023-11-21T00:17:10Z", "info", "running")).To(Succeed())
+		Expect(lg.FinnishTest("TestDone", "2023-11-21T00:17:11Z", "PASS", "1")).To(Succeed())
+		Expect(lg.AddLine("TestDone", "2023-11-21T00:17:12Z", "info", "PASS")).To(Succeed())
+		Expect(lg.Finish("2023-11-21T00:17:13Z")).To(Succeed())
+		Expect(portal.Problems).To(BeEmpty())
+		launch := portal.Launches()[0]
+		Expect(launch.Find("REPORT_SUITE", "TestDone").Logs).To(HaveLen(1))
+		messages := []string{}
+		for _, l := range launch.Logs {
+			messages = append(messages, l.Message)
+		}
+		Expect(messages).To(Equal([]string{"running", "PASS"}))
+	})
+})
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitfield/script"
+)
+
+const (
+	formatAuto       = "auto"
+	formatText       = "text"
+	formatGoTestJSON = "gotest-json"
+	formatJUnit      = "junit"
+)
+
+func isGoTestJSON(line string) bool {
+	ev := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(line), &ev); err != nil {
+		return false
+	}
+	_, ok := ev["Action"]
+	return ok
+}
+
+func isJUnit(line string) bool {
+	return strings.HasPrefix(line, "<?xml") || strings.HasPrefix(line, "<testsuite")
+}
+
+// detectFormat peeks at the first line of the input and returns the detected format
+// together with a pipe that still yields the whole input.
+func detectFormat(filePipe *script.Pipe) (string, *script.Pipe) {
+	br := bufio.NewReader(filePipe)
+	first, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Println(err)
+	}
+	rest := script.NewPipe().WithReader(io.MultiReader(strings.NewReader(first), br))
+	line := strings.TrimSpace(first)
+	switch {
+	case isGoTestJSON(line):
+		return formatGoTestJSON, rest
+	case isJUnit(line):
+		return formatJUnit, rest
+	}
+	return formatText, rest
+}
+
+func process(lg TestReportBuilder, launchName, suiteName string, filePipe *script.Pipe, opts *ParseOptions) error {
+	format := opts.Format
+	if format == formatAuto || format == "" {
+		format, filePipe = detectFormat(filePipe)
+	}
+	if opts.Attributes != nil {
+		filePipe = filePipe.FilterLine(func(line string) string {
+			opts.Attributes.scan(opts.mask(line))
+			return line
+		})
+	}
+	if opts.Resume != nil && format != formatText {
+		return &InputError{Field: "resume", Value: format, Err: fmt.Errorf("only text logs can be resumed")}
+	}
+	switch format {
+	case formatText:
+		return processLinear(lg, launchName, suiteName, filePipe, opts)
+	case formatGoTestJSON:
+		return processGoTestJSON(lg, launchName, suiteName, filePipe, opts)
+	case formatJUnit:
+		return processJUnit(lg, launchName, suiteName, filePipe, opts.NoErrors, opts.location())
+	}
+	return fmt.Errorf("unknown input format %q", format)
+}
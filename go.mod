@@ -2,17 +2,19 @@ module github.com/AdamSaleh/log2reportportal
 
 go 1.18
 
-require github.com/onsi/ginkgo/v2 v2.13.2
+require (
+	github.com/bitfield/script v0.22.0
+	github.com/go-resty/resty/v2 v2.10.0
+	github.com/onsi/ginkgo/v2 v2.13.2
+	golang.org/x/exp v0.0.0-20231206192017-f3f8817b8deb
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/bitfield/script v0.22.0 // indirect
-	github.com/go-resty/resty/v2 v2.10.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/itchyny/gojq v0.12.12 // indirect
 	github.com/itchyny/timefmt-go v0.1.5 // indirect
-	golang.org/x/exp v0.0.0-20231206192017-f3f8817b8deb // indirect
 	golang.org/x/text v0.14.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	mvdan.cc/sh/v3 v3.6.0 // indirect
 )
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitfield/script"
+)
+
+// TestEvent is a single event of the `go test -json` (test2json) stream.
+type TestEvent struct {
+	Time    time.Time `json:"Time"`
+	Action  string    `json:"Action"`
+	Package string    `json:"Package"`
+	Test    string    `json:"Test"`
+	Elapsed float64   `json:"Elapsed"`
+	Output  string    `json:"Output"`
+}
+
+// test2json repeats the framing lines of the plain-text output as output events,
+// these are already represented by the run/pass/fail/skip actions.
+var reFrame = regexp.MustCompile(`^\s*(?:=== (?:RUN|PAUSE|CONT|NAME)|--- (?:PASS|FAIL|SKIP):)`)
+
+func feedEvent(lg TestReportBuilder, launchName, suiteName string, ev *TestEvent) error {
+	if ev.Test == "" {
+		// package level events (build output, package pass/fail) have no test item to attach to
+		return nil
+	}
+	if ev.Package != "" {
+		suiteName = ev.Package
+	}
+	stamp := ev.Time.UTC().Format(time.RFC3339Nano)
+	if err := lg.EnsureLaunch(launchName, suiteName, stamp); err != nil {
+		return err
+	}
+	switch ev.Action {
+	case "run":
+		return lg.EnsureTest(ev.Test, stamp)
+	case "output", "bench":
+		if reFrame.MatchString(ev.Output) {
+			return nil
+		}
+		return addLine(lg, ev.Test, stamp, "", strings.TrimRight(ev.Output, "\n"))
+	case "pass", "fail", "skip":
+		return lg.FinnishTest(ev.Test, stamp, strings.ToUpper(ev.Action),
+			strconv.FormatFloat(ev.Elapsed, 'f', -1, 64))
+	}
+	return nil
+}
+
+func processGoTestJSON(lg TestReportBuilder, launchName, suiteName string, filePipe *script.Pipe, opts *ParseOptions,
+) error {
+	report := &ErrorReport{noErrors: opts.NoErrors}
+	lastTime := ""
+	lineNo := 0
+	stopped := false
+	_, errPipe := filePipe.FilterLine(func(line string) string {
+		lineNo++
+		ev := &TestEvent{}
+		if err := json.Unmarshal([]byte(line), ev); err != nil || ev.Action == "" || stopped {
+			// go test -json interleaves non-json lines, i.e. build failures
+			return opts.mask(line)
+		}
+		if ev.Test != "" {
+			lastTime = ev.Time.UTC().Format(time.RFC3339Nano)
+		}
+		if err := feedEvent(lg, launchName, suiteName, ev); err != nil {
+			stopped = !report.add(&LineError{Line: lineNo, Err: err})
+		}
+		return opts.mask(line)
+	}).WithStdout(opts.echo()).Stdout()
+	if errPipe != nil {
+		report.add(errPipe)
+	}
+	if !stopped && lastTime != "" && lg.getLaunch(launchName) >= 0 {
+		report.add(lg.Finish(lastTime))
+	}
+	return report.err()
+}
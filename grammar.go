@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Actions a grammar rule can trigger in the StateMachine when its pattern matches.
+const (
+	// actionState only copies the captures into the state, i.e. to remember the current test
+	actionState = "state"
+	// actionLog copies the captures and reports msg as a log line of the current test
+	actionLog = "log"
+	// actionEnd copies the captures and finishes the current test with result and duration,
+	// with a continuation the results of the subtests following it are taken along and finished first
+	actionEnd = "end"
+	// actionLine reports the line capture as a log line of the current test
+	actionLine = "line"
+	// actionStep copies the captures, starts the step of the current test and reports msg as its log line
+	actionStep = "step"
+	// actionStepEnd copies the captures, reports msg and finishes the step, failing it when result is failed
+	actionStepEnd = "stepEnd"
+	// actionBlock reports the lines of a block, i.e. a panic, as a single error log line of the current test
+	actionBlock = "block"
+)
+
+// GrammarRule is a named regex using the capture-group contract of DefaultLines:
+// test, step, result, duration, level, msg, timestamp, date, datetime, startDate, lastTime and line.
+// lastTime is the time of the lines without one up to the next time, and the time the clock of the timestamps
+// without a date is rolled over from, i.e. the kuttl startTime.
+// Block rules start with the line matching the pattern and accumulate the following lines
+// while they match continuation, up to and including the first one matching terminator.
+// End rules can have them as well, the lines of their block matching the pattern are finished from the last one.
+type GrammarRule struct {
+	Name         string `yaml:"name"`
+	Pattern      string `yaml:"pattern"`
+	Action       string `yaml:"action"`
+	Continuation string `yaml:"continuation,omitempty"`
+	Terminator   string `yaml:"terminator,omitempty"`
+}
+
+// Grammar is an ordered list of rules, the first rule matching a line wins.
+type Grammar struct {
+	Rules []GrammarRule `yaml:"rules"`
+}
+
+// DefaultGrammar is the built-in kuttl/argo grammar.
+func DefaultGrammar() *Grammar {
+	r := &DefaultLines{}
+	return &Grammar{Rules: []GrammarRule{
+		{Name: "stamp", Pattern: r.reSTAMP(), Action: actionState},
+		{Name: "cont", Pattern: r.reCONT(), Action: actionState},
+		{Name: "pause", Pattern: r.rePAUSE(), Action: actionState},
+		{Name: "run", Pattern: r.reRUN(), Action: actionState},
+		{Name: "step", Pattern: r.reSTEP(), Action: actionStep},
+		{Name: "stepEnd", Pattern: r.reSTEPEND(), Action: actionStepEnd},
+		{Name: "log", Pattern: r.reLOG(), Action: actionLog},
+		{Name: "end", Pattern: r.reEND(), Action: actionEnd, Continuation: r.reENDCONT()},
+		{Name: "panic", Pattern: r.rePANIC(), Action: actionBlock, Continuation: r.rePANICCONT()},
+		{Name: "errorTrace", Pattern: r.reTRACE(), Action: actionBlock, Continuation: r.reTRACECONT()},
+		{Name: "diff", Pattern: r.reDIFF(), Action: actionBlock, Continuation: r.reDIFFCONT()},
+		{Name: "line", Pattern: "(?P<line>^.*$)", Action: actionLine},
+	}}
+}
+
+// loadGrammar reads a grammar from a yaml file, json being a subset of yaml works as well.
+func loadGrammar(path string) (*Grammar, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading grammar: %w", err)
+	}
+	g := &Grammar{}
+	if err := yaml.Unmarshal(b, g); err != nil {
+		return nil, fmt.Errorf("parsing grammar %s: %w", path, err)
+	}
+	if err := g.validate(); err != nil {
+		return nil, fmt.Errorf("invalid grammar %s: %w", path, err)
+	}
+	return g, nil
+}
+
+func (g *Grammar) validate() error {
+	if len(g.Rules) == 0 {
+		return fmt.Errorf("no rules defined")
+	}
+	for _, r := range g.Rules {
+		switch r.Action {
+		case actionState, actionLog, actionLine, actionStep, actionStepEnd:
+			if r.Continuation != "" || r.Terminator != "" {
+				return fmt.Errorf("rule %q: only %s and %s rules can have a continuation or a terminator", r.Name,
+					actionBlock, actionEnd)
+			}
+		case actionEnd:
+		case actionBlock:
+			if r.Continuation == "" && r.Terminator == "" {
+				return fmt.Errorf("rule %q: %s rules need a continuation or a terminator", r.Name, actionBlock)
+			}
+		default:
+			return fmt.Errorf("rule %q: unknown action %q", r.Name, r.Action)
+		}
+		for _, p := range []string{r.Continuation, r.Terminator} {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		// getMatches only reports a match when there is something captured
+		if re.NumSubexp() == 0 {
+			return fmt.Errorf("rule %q: pattern needs at least one capture group", r.Name)
+		}
+	}
+	return nil
+}
+
+// stepResults maps the outcome of a kuttl step to the results of FinnishTest.
+var stepResults = map[string]string{"completed": "PASS", "failed": "FAIL"}
+
+func grammarActions(lg TestReportBuilder, launchName, suiteName string, loc *time.Location,
+) map[string][]Action {
+	// stamp takes the time of the matched line and makes sure the launch is started by then
+	stamp := func(s, m map[string]string) (map[string]string, error) {
+		if s["test"] == "" {
+			return s, nil
+		}
+		s["time"] = lineTime(s, m, loc)
+		if _, err := toUnix(s["time"]); err == nil {
+			s["lastTime"] = s["time"]
+		}
+		return s, lg.EnsureLaunch(launchName, suiteName, s["time"])
+	}
+	return map[string][]Action{
+		actionState: {mapCopy, func(s, m map[string]string) (map[string]string, error) {
+			// the lines up to the next time are from the time the log started at
+			if t, err := parseTime(m["lastTime"], loc); err == nil {
+				s["time"], s["lastTime"] = formatTime(t), formatTime(t)
+			}
+			return s, nil
+		}},
+		actionLog: {mapCopy, stamp, func(s, m map[string]string) (map[string]string, error) {
+			if s["test"] == "" {
+				return s, nil
+			}
+			if m["step"] != "" {
+				if err := lg.EnsureStep(s["test"], m["step"], s["time"]); err != nil {
+					return s, err
+				}
+			}
+			return s, addLine(lg, s["test"], s["time"], s["level"], m["msg"])
+		}},
+		actionStep: {mapCopy, stamp, func(s, m map[string]string) (map[string]string, error) {
+			if s["test"] == "" {
+				return s, nil
+			}
+			if err := lg.EnsureStep(s["test"], m["step"], s["time"]); err != nil {
+				return s, err
+			}
+			return s, addLine(lg, s["test"], s["time"], s["level"], m["msg"])
+		}},
+		actionStepEnd: {mapCopy, stamp, func(s, m map[string]string) (map[string]string, error) {
+			if s["test"] == "" {
+				return s, nil
+			}
+			if err := lg.EnsureStep(s["test"], m["step"], s["time"]); err != nil {
+				return s, err
+			}
+			if err := addLine(lg, s["test"], s["time"], s["level"], m["msg"]); err != nil {
+				return s, err
+			}
+			result, ok := stepResults[m["result"]]
+			if !ok {
+				result = "PASS"
+			}
+			return s, lg.FinnishStep(s["test"], m["step"], s["time"], result)
+		}},
+		actionEnd: {mapCopy, func(s, m map[string]string) (map[string]string, error) {
+			if s["test"] == "" {
+				return s, nil
+			}
+			// a log cut from the middle of a run can start with the result of a test
+			if lg.getLaunch(launchName) < 0 {
+				if err := lg.EnsureLaunch(launchName, suiteName, s["time"]); err != nil {
+					return s, err
+				}
+			}
+			return s, lg.FinnishTest(s["test"], s["time"], m["result"], m["duration"])
+		}},
+		actionBlock: {func(s, m map[string]string) (map[string]string, error) {
+			if s["test"] == "" {
+				return s, nil
+			}
+			s["time"] = tick(s, loc)
+			if err := lg.EnsureLaunch(launchName, suiteName, s["time"]); err != nil {
+				return s, err
+			}
+			if err := lg.EnsureTest(s["test"], s["time"]); err != nil {
+				return s, err
+			}
+			return s, addLine(lg, s["test"], s["time"], "error", m["block"])
+		}},
+		actionLine: {func(s, m map[string]string) (map[string]string, error) {
+			if s["test"] == "" {
+				return s, nil
+			}
+			s["time"] = tick(s, loc)
+			if err := lg.EnsureLaunch(launchName, suiteName, s["time"]); err != nil {
+				return s, err
+			}
+			if err := lg.EnsureTest(s["test"], s["time"]); err != nil {
+				return s, err
+			}
+			return s, addLine(lg, s["test"], s["time"], s["level"], m["line"])
+		}},
+	}
+}
+
+// machine builds the state machine of the grammar, the lines before the first one with a time,
+// or with a clock but no date, are taken to be from start.
+func (g *Grammar) machine(lg TestReportBuilder, launchName, suiteName string, loc *time.Location,
+	start time.Time,
+) *StateMachine {
+	actions := grammarActions(lg, launchName, suiteName, loc)
+	m := mkMachine(map[string]string{
+		"test": "", "level": "", "launch": "",
+		"startDate": start.In(loc).Format("2006-01-02"), "time": formatTime(start), "lastTime": formatTime(start),
+	})
+	for _, r := range g.Rules {
+		switch {
+		case r.Action == actionBlock:
+			m.block(r.Pattern, r.Continuation, r.Terminator, actions[r.Action]...)
+		case r.Action == actionEnd && (r.Continuation != "" || r.Terminator != ""):
+			m.block(r.Pattern, r.Continuation, r.Terminator,
+				subtestsFirst(regexp.MustCompile(r.Pattern), actions[r.Action]))
+		default:
+			m.pattern(r.Pattern, actions[r.Action]...)
+		}
+	}
+	return m
+}
+
+// subtestsFirst runs the actions of an end rule for the lines of its block matching the pattern, the last one first.
+// go test prints the result of a test before the results of its subtests, which have to be finished before it.
+// The state is left with the captures of the last line, as if the lines were run in order.
+// A failing line does not keep the others from being finished, the errors of all of them are returned.
+func subtestsFirst(re *regexp.Regexp, actions []Action) Action {
+	return func(s, m map[string]string) (map[string]string, error) {
+		lines := strings.Split(m["block"], "\n")
+		var last map[string]string
+		report := &ErrorReport{}
+		for i := len(lines) - 1; i >= 0; i-- {
+			mt := getMatches(re, lines[i])
+			if len(mt) == 0 {
+				continue
+			}
+			if last == nil {
+				last = mt
+			}
+			for _, a := range actions {
+				var err error
+				if s, err = a(s, mt); err != nil {
+					report.add(err)
+					break
+				}
+			}
+		}
+		s, _ = mapCopy(s, last)
+		if len(report.Errors) == 1 {
+			return s, report.Errors[0]
+		}
+		return s, report.err()
+	}
+}
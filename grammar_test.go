@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/bitfield/script"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing grammars", func() {
+	It("Built-in grammar matches the shipped yaml", func() {
+		g, err := loadGrammar("./test_data/default-grammar.yaml")
+		Expect(err).To(BeNil())
+		Expect(g).To(Equal(DefaultGrammar()))
+	})
+
+	It("Parses with a custom grammar", func() {
+		g, err := loadGrammar("./test_data/simple-grammar.yaml")
+		Expect(err).To(BeNil())
+		actual := &MockReportBuilder{Cases: CasesType{}}
+		errP := process(actual, "TestName", "TestSuite", script.File("./test_data/simple.log"),
+			&ParseOptions{Format: formatText, Grammar: g, NoErrors: true})
+		Expect(errP).To(BeNil())
+		expectGolden(actual, "./test_data/simple.json")
+	})
+
+	It("Reports panics, testify failures and kuttl diffs as a single error line", func() {
+		tree := NewReportTree()
+		errP := process(tree, "TestName", "TestSuite", script.File("./test_data/blocks.txt"),
+			&ParseOptions{NoErrors: true})
+		Expect(errP).To(BeNil())
+		errorLines := func(name string) []string {
+			lines := []string{}
+			for _, l := range tree.suite.Tests[tree.getCase(name)].Logs {
+				if l.Level == "error" {
+					lines = append(lines, l.Message)
+				}
+			}
+			return lines
+		}
+		Expect(errorLines("1-001_install")).To(ConsistOf(SatisfyAll(
+			HavePrefix("    case.go:366: --- ArgoCD:kuttl-test-enormous-pig/example-argocd\n"),
+			HaveSuffix("\n        +  phase: Pending"),
+		)))
+		Expect(errorLines("TestNamespacedAppWithSecrets")).To(HaveExactElements(
+			SatisfyAll(HavePrefix("    app_management_ns_test.go:690: \n"), HaveSuffix("TestNamespacedAppWithSecrets")),
+			SatisfyAll(HavePrefix("    app_management_ns_test.go:691: \n"), HaveSuffix("TestNamespacedAppWithSecrets")),
+		))
+		Expect(errorLines("TestPanics")).To(ConsistOf(SatisfyAll(
+			HavePrefix("panic: runtime error: invalid memory address or nil pointer dereference [recovered]\n"),
+			HaveSuffix("/usr/local/go/src/testing/testing.go:1648 +0x3ad"),
+		)))
+		Expect(tree.suite.Tests[tree.getCase("TestPanics")].Logs).To(HaveLen(3))
+	})
+
+	DescribeTable("Rejecting invalid grammars",
+		func(g *Grammar, expected string) {
+			Expect(g.validate()).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("no rules", &Grammar{}, "no rules"),
+		Entry("unknown action",
+			&Grammar{Rules: []GrammarRule{{Name: "x", Pattern: "(?P<line>.*)", Action: "jump"}}},
+			`unknown action "jump"`),
+		Entry("broken regex",
+			&Grammar{Rules: []GrammarRule{{Name: "x", Pattern: "(?P<line>.*", Action: actionLine}}},
+			"missing closing )"),
+		Entry("block without an end",
+			&Grammar{Rules: []GrammarRule{{Name: "x", Pattern: "(?P<line>panic: .*)", Action: actionBlock}}},
+			"need a continuation or a terminator"),
+		Entry("continuation of a line rule",
+			&Grammar{Rules: []GrammarRule{{Name: "x", Pattern: "(?P<line>.*)", Action: actionLine, Continuation: `^\t`}}},
+			"only block and end rules"),
+		Entry("broken terminator",
+			&Grammar{Rules: []GrammarRule{{Name: "x", Pattern: "(?P<line>.*)", Action: actionBlock, Terminator: "("}}},
+			"missing closing )"),
+		Entry("no capture group",
+			&Grammar{Rules: []GrammarRule{{Name: "x", Pattern: ".*", Action: actionLine}}},
+			"capture group"),
+	)
+})
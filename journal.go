@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+// Journal is the checkpoint of an upload to the portal, written by RPLogger as the upload goes on.
+// An interrupted upload of a text log is resumed by reattaching to the started items
+// and continuing after the last line which was fully reported.
+type Journal struct {
+	Launch *RPLaunch      `json:"launch"`
+	Suite  *RPItem        `json:"suite"`
+	Tests  []*journalItem `json:"tests,omitempty"`
+	// Line is the number of input lines which are fully reported, State is the state of the parser after them
+	Line  int               `json:"line"`
+	State map[string]string `json:"state,omitempty"`
+	// Finished is set once the launch is finished, there is nothing left to resume then
+	Finished bool `json:"finished,omitempty"`
+}
+
+// journalItem is a started test with what the RPLogger keeps about it besides what is sent to the portal.
+type journalItem struct {
+	RPItem
+	Path   string    `json:"path"`
+	Parent string    `json:"parent,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Steps  []*RPItem `json:"steps,omitempty"`
+}
+
+// loadJournal reads the journal of an interrupted upload.
+func loadJournal(path string) (*Journal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	j := &Journal{}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("parsing journal %s: %w", path, err)
+	}
+	if j.Launch == nil || j.Launch.UUID == "" || j.Suite == nil || j.Suite.UUID == "" {
+		return nil, &InputError{Field: "journal", Value: path, Err: fmt.Errorf("has no launch to resume")}
+	}
+	if j.Finished {
+		return nil, &InputError{Field: "journal", Value: path,
+			Err: fmt.Errorf("launch %s is already finished", j.Launch.Name)}
+	}
+	return j, nil
+}
+
+// resume reattaches to the items started by the interrupted upload.
+func (p *RPLogger) resume(j *Journal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.launch, p.suite = j.Launch, j.Suite
+	p.Tests, p.Steps = nil, map[string][]*RPItem{}
+	byPath := map[string]*RPItem{}
+	for _, item := range j.Tests {
+		ts := item.RPItem
+		ts.path, ts.status, ts.parent = item.Path, item.Status, byPath[item.Parent]
+		byPath[ts.path] = &ts
+		p.Tests = append(p.Tests, &ts)
+		if len(item.Steps) > 0 {
+			p.Steps[ts.path] = item.Steps
+		}
+	}
+	p.progress = Journal{Line: j.Line, State: j.State}
+}
+
+// Checkpoint records that the input is fully reported up to the line, leaving the parser in the state.
+// It uploads the log lines which waited long enough and waits while log lines are still buffered,
+// the journal moves on once they are uploaded.
+func (p *RPLogger) Checkpoint(line int, state map[string]string) error {
+	if err := p.flushStale(); err != nil {
+		return err
+	}
+	if p.JournalPath == "" || time.Since(p.journalWritten) < p.journalEvery {
+		return nil
+	}
+	p.mu.Lock()
+	pending := len(p.batch.logs) > 0
+	if pending {
+		p.waiting = &Journal{Line: line, State: maps.Clone(state)}
+	}
+	p.mu.Unlock()
+	if pending {
+		return nil
+	}
+	p.progress.Line, p.progress.State = line, maps.Clone(state)
+	return p.writeJournal()
+}
+
+// writeJournal saves the started items together with the last checkpoint, replacing the previous journal.
+func (p *RPLogger) writeJournal() error {
+	if p.JournalPath == "" || p.launch == nil {
+		return nil
+	}
+	p.mu.Lock()
+	j := p.progress
+	j.Launch, j.Suite = p.launch, p.suite
+	for _, ts := range p.Tests {
+		item := &journalItem{RPItem: *ts, Path: ts.path, Status: ts.status, Steps: p.Steps[ts.path]}
+		if ts.parent != nil {
+			item.Parent = ts.parent.path
+		}
+		j.Tests = append(j.Tests, item)
+	}
+	b, err := json.MarshalIndent(&j, "", "  ")
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// the journal is replaced at once, an upload killed while writing it still leaves the previous one
+	tmp, err := os.CreateTemp(filepath.Dir(p.JournalPath), filepath.Base(p.JournalPath)+".*")
+	if err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p.JournalPath); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	p.journalWritten = time.Now()
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing resumed uploads", func() {
+	var batches [][]*RPLog
+	var path string
+
+	// journaling makes a logger writing the journal on every checkpoint, with a batch flushed every 10 lines
+	journaling := func() *RPLogger {
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		lg.LaunchLogs = false
+		lg.Batch.MaxCount = 10
+		lg.JournalPath = path
+		lg.journalEvery = 0
+		return lg
+	}
+	uploaded := func() int {
+		count := 0
+		for _, b := range batches {
+			count += len(b)
+		}
+		return count
+	}
+
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		batches = nil
+		path = filepath.Join(GinkgoT().TempDir(), "journal.json")
+		registerPortal()
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log", batchRecorder(&batches))
+	})
+
+	It("Journals the whole upload and refuses to resume a finished launch", func() {
+		lg := journaling()
+		Expect(processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{Checkpoint: lg.Checkpoint})).To(Succeed())
+		b, err := os.ReadFile(path)
+		Expect(err).To(BeNil())
+		j := &Journal{}
+		Expect(json.Unmarshal(b, j)).To(Succeed())
+		Expect(j.Finished).To(BeTrue())
+		Expect(j.Line).To(Equal(723))
+		Expect(j.Launch.UUID).To(Equal("testid"))
+		Expect(j.Tests).To(HaveLen(5))
+		Expect(j.Tests[1].Path).To(Equal("1-009_validate-manage-other-namespace"))
+		Expect(j.Tests[1].Status).To(Equal("passed"))
+		Expect(j.Tests[1].Steps).To(HaveLen(8))
+
+		_, err = loadJournal(path)
+		Expect(err).To(MatchError(ContainSubstring("launch REPORT_NAME is already finished")))
+	})
+
+	It("Moves the checkpoint along a log of tests running one after another", func() {
+		log := &strings.Builder{}
+		for _, test := range []string{"TestAdd", "TestSub", "TestMul", "TestDiv"} {
+			fmt.Fprintf(log, "=== RUN   %s\n    calc_test.go:10: checking %s\n--- PASS: %s (0.01s)\n", test, test, test)
+		}
+		log.WriteString("PASS\n")
+		lg := journaling()
+		lg.Batch.MaxCount = 1
+		checkpoints := map[int]int{}
+		checkpoint := func(line int, state map[string]string) error {
+			err := lg.Checkpoint(line, state)
+			checkpoints[line] = lg.progress.Line
+			return err
+		}
+		Expect(processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.Echo(log.String()),
+			&ParseOptions{StartTime: time.Date(2023, 11, 21, 0, 17, 10, 0, time.UTC), Checkpoint: checkpoint})).To(
+			Succeed())
+		// every line is fully reported once it is parsed, the result of a test once the next line shows
+		// there are no results of subtests following it
+		for line := 1; line <= 13; line++ {
+			if line%3 == 0 {
+				Expect(checkpoints).NotTo(HaveKey(line))
+				continue
+			}
+			Expect(checkpoints).To(HaveKeyWithValue(line, line))
+		}
+	})
+
+	It("Continues an interrupted upload without reporting anything twice", func() {
+		lg := journaling()
+		Expect(processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{Checkpoint: lg.Checkpoint})).To(Succeed())
+		expectedLogs := uploaded()
+		expectedCalls := httpmock.GetCallCountInfo()
+		httpmock.ZeroCallCounters()
+		batches = nil
+		Expect(os.Remove(path)).To(Succeed())
+
+		// the portal goes away in the middle of the upload
+		calls := 0
+		record := batchRecorder(&batches)
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log",
+			func(req *http.Request) (*http.Response, error) {
+				calls++
+				if calls == 5 {
+					return httpmock.NewStringResponse(http.StatusBadGateway, ""), nil
+				}
+				return record(req)
+			})
+		lg = journaling()
+		Expect(processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{Checkpoint: lg.Checkpoint})).To(MatchError(ContainSubstring("502")))
+		j, err := loadJournal(path)
+		Expect(err).To(BeNil())
+		Expect(j.Line).To(BeNumerically(">", 0))
+		Expect(j.Line).To(BeNumerically("<", 723))
+
+		lg = journaling()
+		lg.resume(j)
+		Expect(processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/parallel-kuttl.txt"),
+			&ParseOptions{Resume: j, Checkpoint: lg.Checkpoint})).To(Succeed())
+		Expect(uploaded()).To(Equal(expectedLogs))
+		counts := httpmock.GetCallCountInfo()
+		for _, endpoint := range []string{
+			"POST http://portal/api/v1/TEST_PROJECT/launch", "POST http://portal/api/v1/TEST_PROJECT/item",
+			"POST http://portal/api/v2/TEST_PROJECT/item/testid", "PUT http://portal/api/v1/TEST_PROJECT/item/testid",
+			"PUT http://portal/api/v1/TEST_PROJECT/launch/testid/finish",
+		} {
+			Expect(counts[endpoint]).To(Equal(expectedCalls[endpoint]), endpoint)
+		}
+	})
+
+	It("Resumes only text logs", func() {
+		j := &Journal{Launch: &RPLaunch{Name: "REPORT_NAME", UUID: "testid"}, Suite: &RPItem{UUID: "testid"}}
+		err := process(NewReportTree(), "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/go-test-json.log"),
+			&ParseOptions{Resume: j})
+		Expect(err).To(MatchError(ContainSubstring("only text logs can be resumed")))
+	})
+})
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitfield/script"
+)
+
+type JUnitResult struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type JUnitTestCase struct {
+	Name      string       `xml:"name,attr"`
+	ClassName string       `xml:"classname,attr,omitempty"`
+	Time      string       `xml:"time,attr"`
+	Timestamp string       `xml:"timestamp,attr,omitempty"`
+	Failure   *JUnitResult `xml:"failure"`
+	Error     *JUnitResult `xml:"error"`
+	Skipped   *JUnitResult `xml:"skipped"`
+	SystemOut string       `xml:"system-out,omitempty"`
+	SystemErr string       `xml:"system-err,omitempty"`
+}
+
+type JUnitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Timestamp string           `xml:"timestamp,attr,omitempty"`
+	Time      string           `xml:"time,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	TestCases []JUnitTestCase  `xml:"testcase"`
+	Suites    []JUnitTestSuite `xml:"testsuite"`
+}
+
+// JUnitTestSuites is the root element of a written report, one testsuite per suite of the launch.
+type JUnitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Name    string           `xml:"name,attr"`
+	Suites  []JUnitTestSuite `xml:"testsuite"`
+}
+
+// parseJUnitTimestamp falls back to the given time, the junit schema mandates ISO 8601 without a time zone
+// but other layouts are seen in the wild as well.
+func parseJUnitTimestamp(stamp string, fallback time.Time, loc *time.Location) time.Time {
+	if t, err := parseTime(stamp, loc); err == nil {
+		return t.UTC()
+	}
+	return fallback
+}
+
+func parseJUnitDuration(d string) float64 {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(d, ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func (tc *JUnitTestCase) result() (string, *JUnitResult) {
+	switch {
+	case tc.Failure != nil:
+		return "FAIL", tc.Failure
+	case tc.Error != nil:
+		return "FAIL", tc.Error
+	case tc.Skipped != nil:
+		return "SKIP", tc.Skipped
+	}
+	return "PASS", nil
+}
+
+func addJUnitOutput(lg TestReportBuilder, name, stamp, level, output string) error {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return nil
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if err := addLine(lg, name, stamp, level, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func feedTestCase(lg TestReportBuilder, tc *JUnitTestCase, startTime time.Time) error {
+	stamp := startTime.Format(time.RFC3339Nano)
+	duration := parseJUnitDuration(tc.Time)
+	if err := lg.EnsureTest(tc.Name, stamp); err != nil {
+		return err
+	}
+	if err := addJUnitOutput(lg, tc.Name, stamp, "info", tc.SystemOut); err != nil {
+		return err
+	}
+	if err := addJUnitOutput(lg, tc.Name, stamp, "error", tc.SystemErr); err != nil {
+		return err
+	}
+	result, detail := tc.result()
+	if detail != nil {
+		message := strings.TrimSpace(strings.Join([]string{detail.Message, strings.TrimSpace(detail.Text)}, "\n"))
+		level := "error"
+		if result == "SKIP" {
+			level = "info"
+		}
+		if message != "" {
+			if err := addLine(lg, tc.Name, stamp, level, message); err != nil {
+				return err
+			}
+		}
+	}
+	endStamp := startTime.Add(time.Duration(duration * float64(time.Second))).Format(time.RFC3339Nano)
+	return lg.FinnishTest(tc.Name, endStamp, result, strconv.FormatFloat(duration, 'f', -1, 64))
+}
+
+// feedTestSuite reports the testcases of the suite and its nested suites, returning the time the suite ended.
+// Testcases without their own timestamp are assumed to run one after another from the start of the suite.
+func feedTestSuite(lg TestReportBuilder, launchName, suiteName string, ts *JUnitTestSuite, start time.Time,
+	loc *time.Location, report *ErrorReport,
+) (time.Time, bool) {
+	start = parseJUnitTimestamp(ts.Timestamp, start, loc)
+	if ts.Name != "" {
+		suiteName = ts.Name
+	}
+	current := start
+	for i := range ts.TestCases {
+		tc := &ts.TestCases[i]
+		tcStart := parseJUnitTimestamp(tc.Timestamp, current, loc)
+		err := lg.EnsureLaunch(launchName, suiteName, tcStart.Format(time.RFC3339Nano))
+		if err == nil {
+			err = feedTestCase(lg, tc, tcStart)
+		}
+		if err != nil && !report.add(fmt.Errorf("testcase %s: %w", tc.Name, err)) {
+			return current, false
+		}
+		if end := tcStart.Add(time.Duration(parseJUnitDuration(tc.Time) * float64(time.Second))); end.After(current) {
+			current = end
+		}
+	}
+	for i := range ts.Suites {
+		var ok bool
+		if current, ok = feedTestSuite(lg, launchName, suiteName, &ts.Suites[i], current, loc, report); !ok {
+			return current, false
+		}
+	}
+	if end := start.Add(time.Duration(parseJUnitDuration(ts.Time) * float64(time.Second))); end.After(current) {
+		current = end
+	}
+	// suite level system-out/system-err has no test item to be attached to
+	return current, true
+}
+
+func processJUnit(lg TestReportBuilder, launchName, suiteName string, filePipe *script.Pipe, noErrors bool,
+	loc *time.Location,
+) error {
+	report := &ErrorReport{noErrors: noErrors}
+	decoder := xml.NewDecoder(filePipe)
+	current := time.Now().UTC()
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.add(&InputError{Field: "junit xml", Err: err})
+			return report.err()
+		}
+		// testsuites are streamed one by one, whether wrapped in <testsuites> or not
+		if se, ok := token.(xml.StartElement); ok && se.Name.Local == "testsuite" {
+			ts := &JUnitTestSuite{}
+			if err := decoder.DecodeElement(ts, &se); err != nil {
+				report.add(&InputError{Field: "junit testsuite", Err: err})
+				return report.err()
+			}
+			if current, ok = feedTestSuite(lg, launchName, suiteName, ts, current, loc, report); !ok {
+				return report.err()
+			}
+		}
+	}
+	if lg.getLaunch(launchName) >= 0 {
+		report.add(lg.Finish(current.Format(time.RFC3339Nano)))
+	}
+	return report.err()
+}
+
+func formatJUnitDuration(d float64) string {
+	return strconv.FormatFloat(d, 'f', -1, 64)
+}
+
+// junitSuite converts a suite of the report, its time spans from its start to the end of the last test.
+func junitSuite(s *SuiteNode) JUnitTestSuite {
+	ts := JUnitTestSuite{Name: s.Name, Timestamp: s.StartTime, Time: "0", Tests: len(s.Tests)}
+	start, errS := time.Parse(time.RFC3339, s.StartTime)
+	end := start
+	for _, t := range s.Tests {
+		tc := JUnitTestCase{Name: t.Name, ClassName: s.Name, Timestamp: t.StartTime, Time: formatJUnitDuration(t.Duration)}
+		output := []string{}
+		for _, l := range t.Logs {
+			output = append(output, l.Message)
+		}
+		tc.SystemOut = strings.Join(output, "\n")
+		switch t.Result {
+		case "FAIL":
+			tc.Failure = &JUnitResult{Message: "failed"}
+			ts.Failures++
+		case "SKIP":
+			tc.Skipped = &JUnitResult{}
+			ts.Skipped++
+		}
+		if tStart, err := time.Parse(time.RFC3339, t.StartTime); err == nil {
+			if tEnd := tStart.Add(time.Duration(t.Duration * float64(time.Second))); tEnd.After(end) {
+				end = tEnd
+			}
+		}
+		ts.TestCases = append(ts.TestCases, tc)
+	}
+	if errS == nil {
+		ts.Time = formatJUnitDuration(end.Sub(start).Seconds())
+	}
+	return ts
+}
+
+func writeJUnit(r *ReportTree, w io.Writer) error {
+	report := &JUnitTestSuites{}
+	if r.Launch != nil {
+		report.Name = r.Launch.Name
+		for _, s := range r.Launch.Suites {
+			report.Suites = append(report.Suites, junitSuite(s))
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// LaunchFilter selects the launches of a project, the newest first.
+type LaunchFilter struct {
+	// Name is a part of the launch name, Attributes are key:value or value the launches all have
+	Name       string
+	Attributes AttrFlag
+	Limit      int
+}
+
+func (f *LaunchFilter) query() map[string]string {
+	q := map[string]string{"page.sort": "startTime,DESC"}
+	if f.Limit > 0 {
+		q["page.size"] = strconv.Itoa(f.Limit)
+	}
+	if f.Name != "" {
+		q["filter.cnt.name"] = f.Name
+	}
+	if len(f.Attributes) > 0 {
+		q["filter.has.compositeAttribute"] = attrList(f.Attributes, ",")
+	}
+	return q
+}
+
+// searchLaunches lists the launches of the project matching the filter.
+func searchLaunches(client *resty.Client, project string, filter *LaunchFilter) ([]RPLaunch, error) {
+	url := fmt.Sprintf("api/v1/%s/launch", project)
+	result := &Launches{}
+	resp, err := client.R().SetQueryParams(filter.query()).SetResult(result).Get(url)
+	if err := checkResponse(http.MethodGet, url, resp, err); err != nil {
+		return nil, err
+	}
+	return result.Content, nil
+}
+
+// findLaunches resolves the launches given by id or by their exact name, every launch of the name is taken.
+func findLaunches(client *resty.Client, project string, ids, names []string) ([]RPLaunch, error) {
+	launches := []RPLaunch{}
+	for _, id := range ids {
+		url := fmt.Sprintf("api/v1/%s/launch/%s", project, id)
+		l := RPLaunch{}
+		resp, err := client.R().SetResult(&l).Get(url)
+		if err := checkResponse(http.MethodGet, url, resp, err); err != nil {
+			return nil, withItem(id, err)
+		}
+		launches = append(launches, l)
+	}
+	for _, name := range names {
+		url := fmt.Sprintf("api/v1/%s/launch", project)
+		result := &Launches{}
+		resp, err := client.R().SetQueryParam("filter.eq.name", name).SetResult(result).Get(url)
+		if err := checkResponse(http.MethodGet, url, resp, err); err != nil {
+			return nil, withItem(name, err)
+		}
+		if len(result.Content) == 0 {
+			return nil, &InputError{Field: "launch", Value: name, Err: fmt.Errorf("no launch with this name")}
+		}
+		launches = append(launches, result.Content...)
+	}
+	return launches, nil
+}
+
+// deleteLaunch removes a finished launch with all its items.
+func deleteLaunch(client *resty.Client, project string, l RPLaunch) error {
+	url := fmt.Sprintf("api/v1/%s/launch/%s", project, l.ID)
+	resp, err := client.R().Delete(url)
+	return withItem(l.Name, checkResponse(http.MethodDelete, url, resp, err))
+}
+
+// MergeRequest joins several launches into a new one, the merged launches are removed.
+type MergeRequest struct {
+	Name       string        `json:"name"`
+	Launches   []json.Number `json:"launches"`
+	Type       string        `json:"mergeType"`
+	Attributes []RPAttribute `json:"attributes,omitempty"`
+	// ExtendSuitesDescription notes the launch every suite came from in its description
+	ExtendSuitesDescription bool `json:"extendSuitesDescription"`
+}
+
+// mergeLaunches merges the launches, BASIC keeps their suites side by side, DEEP merges the suites of the same name.
+func mergeLaunches(client *resty.Client, project string, rq *MergeRequest) (*RPLaunch, error) {
+	url := fmt.Sprintf("api/v1/%s/launch/merge", project)
+	merged := &RPLaunch{}
+	resp, err := client.R().SetBody(rq).SetResult(merged).Post(url)
+	if err := checkResponse(http.MethodPost, url, resp, err); err != nil {
+		return nil, withItem(rq.Name, err)
+	}
+	return merged, nil
+}
+
+// printLaunches writes the launches as a table.
+func printLaunches(w io.Writer, launches []RPLaunch) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tNUMBER\tSTATUS\tSTART\tATTRIBUTES")
+	for _, l := range launches {
+		start := ""
+		if l.StartTime > 0 {
+			start = formatTime(time.UnixMilli(int64(l.StartTime)))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", l.ID, l.Name, l.Number, l.Status, start,
+			attrList(l.Attributes, " "))
+	}
+	return tw.Flush()
+}
+
+// attrList joins the attributes as key:value, or just the value when there is no key.
+func attrList(attrs []RPAttribute, sep string) string {
+	list := []string{}
+	for _, a := range attrs {
+		if a.Key == "" {
+			list = append(list, a.Value)
+			continue
+		}
+		list = append(list, a.Key+":"+a.Value)
+	}
+	return strings.Join(list, sep)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The log levels of ReportPortal, from the least to the most severe.
+var rpLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// levelRank orders the levels for -minLevel, -1 for unknown ones.
+func levelRank(level string) int {
+	for i, l := range rpLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// LevelRule infers the level of lines logged without one from their message.
+type LevelRule struct {
+	Pattern string `yaml:"pattern"`
+	Level   string `yaml:"level"`
+	re      *regexp.Regexp
+}
+
+// LevelMapper normalises the levels of the log lines to the ones of ReportPortal.
+// Levels maps the captured levels, i.e. logrus warning to warn. The message of lines without a level
+// is matched against Rules, the first matching rule decides, the level is info when none matches.
+type LevelMapper struct {
+	Levels map[string]string `yaml:"levels"`
+	Rules  []LevelRule       `yaml:"rules"`
+}
+
+// DefaultLevelMapper understands logrus, klog and testify output.
+func DefaultLevelMapper() *LevelMapper {
+	lm := &LevelMapper{
+		Levels: map[string]string{
+			"trace": "trace", "debug": "debug", "info": "info", "notice": "info",
+			"warn": "warn", "warning": "warn", "error": "error", "err": "error",
+			"fatal": "fatal", "panic": "fatal", "critical": "fatal",
+			// klog severities, i.e. E1121 00:17:10.884 ...
+			"i": "info", "w": "warn", "e": "error", "f": "fatal",
+		},
+		Rules: []LevelRule{
+			{Pattern: `^\s*(?P<level>[IWEF])\d{4} \d\d:\d\d:\d\d`},
+			{Pattern: `\blevel=(?P<level>\w+)`},
+			{Pattern: `^\s*(?:Warning|WARNING|warning):`, Level: "warn"},
+			{Pattern: `^\s*(?:Error Trace|Error):\s`, Level: "error"},
+			{Pattern: `^\s*--- FAIL`, Level: "error"},
+			{Pattern: `^\s*panic: `, Level: "fatal"},
+		},
+	}
+	if err := lm.compile(); err != nil {
+		panic(err)
+	}
+	return lm
+}
+
+// loadLevelMapper reads a mapping table from a yaml file, it extends and overrides the defaults.
+func loadLevelMapper(path string) (*LevelMapper, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading levels: %w", err)
+	}
+	custom := &LevelMapper{}
+	if err := yaml.Unmarshal(b, custom); err != nil {
+		return nil, fmt.Errorf("parsing levels %s: %w", path, err)
+	}
+	if err := custom.compile(); err != nil {
+		return nil, fmt.Errorf("invalid levels %s: %w", path, err)
+	}
+	lm := DefaultLevelMapper()
+	for raw, level := range custom.Levels {
+		lm.Levels[strings.ToLower(raw)] = level
+	}
+	lm.Rules = append(custom.Rules, lm.Rules...)
+	return lm, nil
+}
+
+// compile checks the table, rules without a level need a level capture group mapped by Levels.
+func (lm *LevelMapper) compile() error {
+	for raw, level := range lm.Levels {
+		if levelRank(level) < 0 {
+			return fmt.Errorf("level %q: unknown level %q, use one of %s", raw, level, strings.Join(rpLevels, ", "))
+		}
+	}
+	for i := range lm.Rules {
+		r := &lm.Rules[i]
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Pattern, err)
+		}
+		if r.Level == "" && re.SubexpIndex("level") < 0 {
+			return fmt.Errorf("rule %q: needs a level or a level capture group", r.Pattern)
+		}
+		if r.Level != "" && levelRank(r.Level) < 0 {
+			return fmt.Errorf("rule %q: unknown level %q, use one of %s", r.Pattern, r.Level, strings.Join(rpLevels, ", "))
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// level maps the captured level, inferring it from the message when there is none.
+// failed tells that the test already failed, its remaining output is the reason why.
+func (lm *LevelMapper) level(raw, message string, failed bool) string {
+	if level, ok := lm.Levels[strings.ToLower(raw)]; ok {
+		return level
+	}
+	for _, r := range lm.Rules {
+		if r.Level != "" && r.re.MatchString(message) {
+			return r.Level
+		}
+		if level, ok := lm.Levels[strings.ToLower(getMatches(r.re, message)["level"])]; ok {
+			return level
+		}
+	}
+	if failed {
+		return "error"
+	}
+	return "info"
+}
+
+// levelFilter is a TestReportBuilder normalising the levels of the log lines, dropping the ones below minLevel.
+type levelFilter struct {
+	TestReportBuilder
+	mapper   *LevelMapper
+	minLevel int
+	// failed are the tests which finished as failed, like go test prints their failures after --- FAIL
+	failed map[string]bool
+}
+
+func newLevelFilter(inner TestReportBuilder, mapper *LevelMapper, minLevel string) (*levelFilter, error) {
+	rank := 0
+	if minLevel != "" {
+		if rank = levelRank(minLevel); rank < 0 {
+			return nil, &InputError{Field: "min level", Value: minLevel,
+				Err: fmt.Errorf("use one of %s", strings.Join(rpLevels, ", "))}
+		}
+	}
+	return &levelFilter{TestReportBuilder: inner, mapper: mapper, minLevel: rank, failed: map[string]bool{}}, nil
+}
+
+func (f *levelFilter) AddLine(name, startTime, level, message string) error {
+	level = f.mapper.level(level, message, f.failed[name])
+	if levelRank(level) < f.minLevel {
+		return nil
+	}
+	return f.TestReportBuilder.AddLine(name, startTime, level, message)
+}
+
+func (f *levelFilter) FinnishTest(name, startTime, result, t string) error {
+	if result == "FAIL" {
+		f.failed[name] = true
+	}
+	return f.TestReportBuilder.FinnishTest(name, startTime, result, t)
+}
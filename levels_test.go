@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/bitfield/script"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing log levels", func() {
+	DescribeTable("Mapping the level of a line", func(raw, message string, failed bool, expected string) {
+		Expect(DefaultLevelMapper().level(raw, message, failed)).To(Equal(expected))
+	},
+		Entry("logrus warning", "warning", "retrying", false, "warn"),
+		Entry("logrus level in upper case", "ERROR", "failed", false, "error"),
+		Entry("logrus panic", "panic", "boom", false, "fatal"),
+		Entry("klog error", "", "E1121 00:17:10.884278   12345 reflector.go:138] failed to list", false, "error"),
+		Entry("klog warning", "", "W1121 00:17:10.884278   12345 warnings.go:70] deprecated", false, "warn"),
+		Entry("logrus text in a kuttl line", "", ` time="2023-11-21T00:19:34Z" level=debug msg="synced"`, false, "debug"),
+		Entry("Warning: prefix", "", " Warning: resource is deprecated", false, "warn"),
+		Entry("testify Error:", "", "        \tError:      \tShould be true", false, "error"),
+		Entry("go test failure", "", "--- FAIL: TestDivide (1.25s)", false, "error"),
+		Entry("output of a failed test", "", "    calc_test.go:12: division by zero", true, "error"),
+		Entry("unknown level", "", " Creating namespace: kuttl-test-enormous-pig", false, "info"),
+	)
+
+	It("Extends the defaults with a mapping table", func() {
+		lm, err := loadLevelMapper("./test_data/levels.yaml")
+		Expect(err).To(BeNil())
+		Expect(lm.level("SEVERE", "disk full", false)).To(Equal("fatal"))
+		Expect(lm.level("", "[verbose] cache hit", false)).To(Equal("debug"))
+		Expect(lm.level("", " Deleting namespace: kuttl-test-enormous-pig", false)).To(Equal("debug"))
+		Expect(lm.level("warning", "retrying", false)).To(Equal("warn"))
+	})
+
+	DescribeTable("Rejecting invalid mappings", func(lm *LevelMapper, expected string) {
+		Expect(lm.compile()).To(MatchError(ContainSubstring(expected)))
+	},
+		Entry("unknown level", &LevelMapper{Levels: map[string]string{"SEVERE": "critical"}},
+			`unknown level "critical"`),
+		Entry("rule without a level", &LevelMapper{Rules: []LevelRule{{Pattern: "^FAILED"}}},
+			"needs a level or a level capture group"),
+		Entry("broken rule", &LevelMapper{Rules: []LevelRule{{Pattern: "(", Level: "error"}}},
+			"missing closing )"),
+	)
+
+	It("Drops the lines below the minimal level", func() {
+		tree := NewReportTree()
+		lm, err := loadLevelMapper("./test_data/levels.yaml")
+		Expect(err).To(BeNil())
+		f, err := newLevelFilter(tree, lm, "info")
+		Expect(err).To(BeNil())
+		errP := process(f, "TestName", "TestSuite", script.File("./test_data/kuttl-steps.txt"),
+			&ParseOptions{NoErrors: true})
+		Expect(errP).To(BeNil())
+		for _, t := range tree.suite.Tests {
+			for _, l := range t.Logs {
+				Expect(l.Level).To(BeElementOf("info", "warn", "error", "fatal"))
+				Expect(l.Message).ToNot(ContainSubstring("Deleting namespace"))
+			}
+		}
+		Expect(tree.suite.Tests[tree.getCase("1-001_install")].Logs).To(HaveLen(6))
+	})
+
+	It("Rejects an unknown minimal level", func() {
+		_, err := newLevelFilter(NewReportTree(), DefaultLevelMapper(), "verbose")
+		Expect(err).To(MatchError(ContainSubstring(`bad min level "verbose"`)))
+	})
+})
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+// batchBoundary separates the parts of a batch upload. The only part is json encoded, in which
+// line breaks are always escaped, so a fixed boundary can not clash with the content
+// and keeps the requests reproducible.
+const batchBoundary = "log2reportportal-batch"
+
+// rpLogOverhead approximates the size of a RPLog without its message, i.e. uuids and time.
+const rpLogOverhead = 200
+
+// LogBatchOptions are the thresholds for flushing the buffered log lines, whichever is reached first.
+// MaxWait is checked whenever the upload moves on, i.e. a line is added, an item finishes or a line is parsed,
+// so buffered lines are not held back by a stretch of the log without lines to report.
+type LogBatchOptions struct {
+	MaxCount int
+	MaxBytes int
+	MaxWait  time.Duration
+}
+
+func DefaultLogBatchOptions() LogBatchOptions {
+	return LogBatchOptions{MaxCount: 100, MaxBytes: 1 << 20, MaxWait: 5 * time.Second}
+}
+
+type logBatch struct {
+	logs    []*RPLog
+	size    int
+	started time.Time
+}
+
+func (b *logBatch) add(l *RPLog) {
+	if len(b.logs) == 0 {
+		b.started = time.Now()
+	}
+	b.logs = append(b.logs, l)
+	b.size += len(l.Message) + rpLogOverhead
+}
+
+func (b *logBatch) full(o LogBatchOptions) bool {
+	return len(b.logs) >= o.MaxCount || b.size >= o.MaxBytes || time.Since(b.started) >= o.MaxWait
+}
+
+// holds tells whether any of the buffered logs belongs to the item.
+func (b *logBatch) holds(uuid string) bool {
+	for _, l := range b.logs {
+		if l.ItemUUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// take empties the batch, returning the logs it held.
+func (b *logBatch) take() []*RPLog {
+	logs := b.logs
+	b.logs = nil
+	b.size = 0
+	return logs
+}
+
+// multipartLogs encodes the logs as the json_request_part of the multipart log endpoint.
+func multipartLogs(logs []*RPLog) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	if err := w.SetBoundary(batchBoundary); err != nil {
+		return nil, "", err
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="json_request_part"`)
+	h.Set("Content-Type", "application/json")
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.NewEncoder(part).Encode(logs); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// takeLogs empties the batch for an upload, which finishing items wait for until uploadLogs is done.
+// The caller holds p.mu, so that no item finishes between taking the logs and sending them.
+// It returns the checkpoint which waited for the logs as well, it is reached once they are uploaded.
+func (p *RPLogger) takeLogs() ([]*RPLog, *Journal) {
+	logs, waiting := p.batch.take(), p.waiting
+	p.waiting = nil
+	if len(logs) > 0 {
+		p.uploads.RLock()
+	}
+	return logs, waiting
+}
+
+// flushLogs uploads the buffered log lines in a single request.
+func (p *RPLogger) flushLogs() error {
+	p.mu.Lock()
+	logs, waiting := p.takeLogs()
+	p.mu.Unlock()
+	return p.uploadLogs(logs, waiting)
+}
+
+// flushStale uploads the buffered log lines once the oldest of them waited for MaxWait.
+func (p *RPLogger) flushStale() error {
+	p.mu.Lock()
+	var logs []*RPLog
+	var waiting *Journal
+	if len(p.batch.logs) > 0 && time.Since(p.batch.started) >= p.Batch.MaxWait {
+		logs, waiting = p.takeLogs()
+	}
+	p.mu.Unlock()
+	return p.uploadLogs(logs, waiting)
+}
+
+// uploadLogs sends the logs taken by takeLogs outside of the lock, so that the other workers can keep buffering
+// meanwhile. Once they are uploaded the journal moves on to the checkpoint which waited for them,
+// it is written with the next item.
+func (p *RPLogger) uploadLogs(logs []*RPLog, waiting *Journal) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	defer p.uploads.RUnlock()
+	body, contentType, err := multipartLogs(logs)
+	if err != nil {
+		return err
+	}
+	count := len(logs)
+	url := fmt.Sprintf("api/v2/%s/log", p.project)
+	resp, err := p.requestWithAuth().
+		SetHeader("Content-Type", contentType).
+		SetBody(body).
+		Post(url)
+	if err := checkResponse(http.MethodPost, url, resp, err); err != nil {
+		return withItem(fmt.Sprintf("%d log lines", count), err)
+	}
+	if waiting != nil {
+		p.mu.Lock()
+		p.progress.Line, p.progress.State = waiting.Line, waiting.State
+		p.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// batchRecorder decodes the json_request_part of every batch upload.
+func batchRecorder(batches *[][]*RPLog) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		Expect(err).To(BeNil())
+		part, err := multipart.NewReader(req.Body, params["boundary"]).NextPart()
+		Expect(err).To(BeNil())
+		Expect(part.FormName()).To(Equal("json_request_part"))
+		b, err := io.ReadAll(part)
+		Expect(err).To(BeNil())
+		logs := []*RPLog{}
+		Expect(json.Unmarshal(b, &logs)).To(Succeed())
+		*batches = append(*batches, logs)
+		return httpmock.NewJsonResponse(201, map[string]any{"responses": []any{}})
+	}
+}
+
+// longTest is a go test logging n lines, which finishes only once all of them are logged.
+func longTest(n int) *script.Pipe {
+	log := "=== RUN   TestLong\n"
+	for i := 1; i <= n; i++ {
+		log += fmt.Sprintf("    long_test.go:12: waiting for the deployment, attempt %03d\n", i)
+	}
+	return script.Echo(log + "--- PASS: TestLong (120.00s)\n")
+}
+
+func registerPortal() {
+	tr := map[string]string{"id": "testid"}
+	tu := map[string]string{"uuid": "testid"}
+	httpmock.RegisterResponder("POST", "http://portal/api/v1/TEST_PROJECT/launch", mockOkJSON(tr))
+	httpmock.RegisterResponder("GET", "http://portal/api/v1/TEST_PROJECT/launch/testid", mockOkJSON(tu))
+	httpmock.RegisterResponder("PUT", "http://portal/api/v1/TEST_PROJECT/launch/testid/finish", mockOkJSON(tr))
+	httpmock.RegisterResponder("POST", "http://portal/api/v1/TEST_PROJECT/item", mockOkJSON(tr))
+	httpmock.RegisterResponder("GET", "http://portal/api/v1/TEST_PROJECT/item/testid", mockOkJSON(tu))
+	httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item/testid", mockOkJSON(tr))
+	httpmock.RegisterResponder("PUT", "http://portal/api/v1/TEST_PROJECT/item/testid", mockOkJSON(tr))
+}
+
+var _ = Describe("Testing batched log upload", func() {
+	var batches [][]*RPLog
+
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		batches = nil
+		registerPortal()
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log", batchRecorder(&batches))
+	})
+
+	DescribeTable("Flushing by thresholds",
+		func(launchLogs bool, batch LogBatchOptions, expectedSizes []int) {
+			lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+			lg.LaunchLogs = launchLogs
+			lg.Batch = batch
+			// the logs of an item are uploaded before it finishes, so the thresholds show in a test which runs long
+			errP := processLinear(lg, "REPORT_NAME", "REPORT_SUITE", longTest(120),
+				&ParseOptions{NoErrors: true, StartTime: time.Date(2023, 11, 21, 0, 17, 10, 0, time.UTC)})
+			Expect(errP).To(BeNil())
+			sizes := []int{}
+			for _, b := range batches {
+				sizes = append(sizes, len(b))
+			}
+			Expect(sizes).To(Equal(expectedSizes))
+		},
+		Entry("everything in one batch, flushed on finish", false,
+			LogBatchOptions{MaxCount: 1000, MaxBytes: 1 << 20, MaxWait: time.Hour}, []int{120}),
+		Entry("by count", false,
+			LogBatchOptions{MaxCount: 50, MaxBytes: 1 << 20, MaxWait: time.Hour}, []int{50, 50, 20}),
+		Entry("by size", false,
+			LogBatchOptions{MaxCount: 1000, MaxBytes: 100 * rpLogOverhead, MaxWait: time.Hour}, []int{77, 43}),
+		Entry("by time", false,
+			LogBatchOptions{MaxCount: 1000, MaxBytes: 1 << 20, MaxWait: 0}, repeat(1, 120)),
+		Entry("with launch logs", true,
+			LogBatchOptions{MaxCount: 100, MaxBytes: 1 << 20, MaxWait: time.Hour}, []int{100, 100, 40}),
+	)
+
+	It("Uploads the lines which waited long enough while the log has nothing to report", func() {
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		lg.LaunchLogs = false
+		lg.Batch.MaxWait = 50 * time.Millisecond
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.AddLine("test", "2023-11-21T00:17:10Z", "info", "hello")).To(Succeed())
+		Expect(lg.Checkpoint(2, nil)).To(Succeed())
+		Expect(batches).To(BeEmpty())
+		time.Sleep(lg.Batch.MaxWait)
+		Expect(lg.Checkpoint(3, nil)).To(Succeed())
+		Expect(batches).To(HaveLen(1))
+		Expect(batches[0][0].Message).To(Equal("hello"))
+	})
+
+	It("Uploads the lines of a test before finishing it and logs to the launch after", func() {
+		requests := []string{}
+		record := batchRecorder(&batches)
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/log",
+			func(req *http.Request) (*http.Response, error) {
+				requests = append(requests, "logs")
+				return record(req)
+			})
+		httpmock.RegisterResponder("PUT", "http://portal/api/v1/TEST_PROJECT/item/testid",
+			func(req *http.Request) (*http.Response, error) {
+				requests = append(requests, "finish")
+				return httpmock.NewJsonResponse(200, map[string]string{"id": "testid"})
+			})
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		lg.Batch.MaxWait = time.Hour
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.AddLine("test", "2023-11-21T00:17:10Z", "info", "hello")).To(Succeed())
+		Expect(lg.FinnishTest("test", "2023-11-21T00:17:11Z", "PASS", "1")).To(Succeed())
+		Expect(requests).To(Equal([]string{"logs", "finish"}))
+		Expect(lg.AddLine("test", "2023-11-21T00:17:12Z", "info", "PASS")).To(Succeed())
+		Expect(lg.flushLogs()).To(Succeed())
+		Expect(batches).To(HaveLen(2))
+		Expect(batches[1]).To(HaveLen(1))
+		Expect(batches[1][0].ItemUUID).To(BeEmpty())
+		Expect(batches[1][0].Message).To(Equal("PASS"))
+	})
+
+	It("Duplicates lines on the launch only when asked to", func() {
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+		Expect(lg.AddLine("test", "2023-11-21T00:17:10Z", "info", "hello")).To(Succeed())
+		lg.LaunchLogs = false
+		Expect(lg.AddLine("test", "2023-11-21T00:17:11Z", "info", "world")).To(Succeed())
+		Expect(lg.Finish("2023-11-21T00:17:12Z")).To(Succeed())
+		Expect(batches).To(HaveLen(1))
+		Expect(batches[0]).To(HaveLen(3))
+		Expect(batches[0][0].ItemUUID).To(Equal("testid"))
+		Expect(batches[0][1].ItemUUID).To(BeEmpty())
+		Expect(batches[0][1].Message).To(Equal("hello"))
+		Expect(batches[0][2].Message).To(Equal("world"))
+		Expect(batches[0][2].ItemUUID).To(Equal("testid"))
+		uuids := map[string]bool{}
+		for _, l := range batches[0] {
+			uuids[l.UUID] = true
+		}
+		Expect(uuids).To(HaveLen(3))
+	})
+})
+
+func repeat(v, n int) []int {
+	o := make([]int, n)
+	for i := range o {
+		o[i] = v
+	}
+	return o
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
-	"context"
-	"crypto/tls"
 	"encoding/json"
-	"flag"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bitfield/script"
@@ -19,13 +20,21 @@ import (
 )
 
 type RPLaunch struct {
-	Name      string      `json:"name,omitempty"`
-	UUID      string      `json:"uuid,omitempty"`
-	ID        json.Number `json:"id,omitempty"`
-	RerunOf   string      `json:"rerunOf,omitempty"`
-	StartTime int         `json:"startTime,omitempty"`
-	EndTime   int         `json:"endTime,omitempty"`
-	Rerun     bool        `json:"rerun,omitempty"`
+	Name       string        `json:"name,omitempty"`
+	UUID       string        `json:"uuid,omitempty"`
+	ID         json.Number   `json:"id,omitempty"`
+	RerunOf    string        `json:"rerunOf,omitempty"`
+	StartTime  int           `json:"startTime,omitempty"`
+	EndTime    int           `json:"endTime,omitempty"`
+	Rerun      bool          `json:"rerun,omitempty"`
+	Attributes []RPAttribute `json:"attributes,omitempty"`
+	// Number and Status are only read from the portal
+	Number int    `json:"number,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+func (l *RPLaunch) setUUID(uuid string) {
+	l.UUID = uuid
 }
 
 type Launches struct {
@@ -33,19 +42,25 @@ type Launches struct {
 }
 
 type RPItem struct {
-	Name        string      `json:"name,omitempty"`
-	Type        string      `json:"type,omitempty"`
-	LaunchUUID  string      `json:"launchUuid"`
-	Description string      `json:"description"`
-	UUID        string      `json:"uuid,omitempty"`
-	ID          json.Number `json:"id,omitempty"`
-	StartTime   int         `json:"startTime,omitempty"`
-	EndTime     int         `json:"endTime,omitempty"`
+	Name        string        `json:"name,omitempty"`
+	Type        string        `json:"type,omitempty"`
+	LaunchUUID  string        `json:"launchUuid"`
+	Description string        `json:"description"`
+	UUID        string        `json:"uuid,omitempty"`
+	ID          json.Number   `json:"id,omitempty"`
+	StartTime   int           `json:"startTime,omitempty"`
+	EndTime     int           `json:"endTime,omitempty"`
+	Attributes  []RPAttribute `json:"attributes,omitempty"`
+	// path is the full name of a test, its subtests are nested under parent
+	path   string
+	parent *RPItem
+	// status is set once the item is finished
+	status string
 }
 
-/*func (i *RPItem) setUUID(uuid string) {
+func (i *RPItem) setUUID(uuid string) {
 	i.UUID = uuid
-}*/
+}
 
 type RPFinishItem struct {
 	Type        string `json:"type"`
@@ -56,22 +71,29 @@ type RPFinishItem struct {
 }
 
 type RPLog struct {
-	LaunchUUID string `json:"launchUuid"`
-	Time       string `json:"time"`
-	ItemUUID   string `json:"itemUuid"`
-	Message    string `json:"message"`
-	Level      string `json:"level"`
-	UUID       string `json:"uuid,omitempty"`
+	LaunchUUID string  `json:"launchUuid"`
+	Time       string  `json:"time"`
+	ItemUUID   string  `json:"itemUuid"`
+	Message    string  `json:"message"`
+	Level      string  `json:"level"`
+	UUID       string  `json:"uuid,omitempty"`
+	File       *RPFile `json:"file,omitempty"`
 }
 
-/*func (i *RPLog) setUUID(uuid string) {
+func (i *RPLog) setUUID(uuid string) {
 	i.UUID = uuid
-}*/
+}
 
 type ResultID struct {
 	ID string `json:"id"` // beware, returns uuid
 }
 
+// RPErrorBody is what ReportPortal responds with when it rejects a request.
+type RPErrorBody struct {
+	ErrorCode int    `json:"errorCode"`
+	Message   string `json:"message"`
+}
+
 type RPLogger struct {
 	project   string
 	authToken string
@@ -79,73 +101,128 @@ type RPLogger struct {
 	suite     *RPItem
 	client    *resty.Client
 	Tests     []*RPItem
+	// Steps are the started steps of every test by its name, the open one has no EndTime yet
+	Steps map[string][]*RPItem
+	// newUUID pre-generates the uuids of started items, so that retried starts are idempotent
+	newUUID func() (string, error)
+	// LaunchLogs duplicates every log line on the launch itself
+	LaunchLogs bool
+	// FlattenSubtests reports go subtests like TestFoo/case_1 as siblings of their parent instead of nested in it
+	FlattenSubtests bool
+	Batch           LogBatchOptions
+	batch           logBatch
+	// Attributes are set on the launch when it is started
+	Attributes []RPAttribute
+	// Scanner collects more launch attributes from the log, they are added when the launch is finished
+	Scanner *AttributeScanner
+	// TestAttributePatterns derive the attributes of every test from its name
+	TestAttributePatterns []*regexp.Regexp
+	// JournalPath is where the started items and the progress through the input are written as the upload goes on,
+	// so that an interrupted upload can be resumed, no journal is written when empty
+	JournalPath    string
+	journalEvery   time.Duration
+	journalWritten time.Time
+	progress       Journal
+	// waiting is the last checkpoint skipped while log lines were buffered, it is reached once they are uploaded
+	waiting *Journal
+	// mu guards Tests, Steps and batch, which are shared by the workers of the concurrent pipeline
+	mu sync.Mutex
+	// uploads is held for reading by the uploads of logs in flight, an item is finished once they are done
+	uploads sync.RWMutex
 }
 
 func (p *RPLogger) requestWithAuth() *resty.Request {
 	return p.client.R().SetAuthToken(p.authToken)
 }
 
-func (p *RPLogger) gPortalItem(apiPath, parent, id string, item interface{}) {
+// checkResponse turns transport failures and non-2xx responses into a PortalError,
+// decoding the error body of ReportPortal when there is one.
+func checkResponse(method, url string, resp *resty.Response, err error) error {
+	if err != nil {
+		return &PortalError{Method: method, Path: url, Err: err}
+	}
+	if resp.IsSuccess() {
+		return nil
+	}
+	pe := &PortalError{Method: method, Path: url, StatusCode: resp.StatusCode(), Status: resp.Status()}
+	body := &RPErrorBody{}
+	if json.Unmarshal(resp.Body(), body) == nil {
+		pe.ErrorCode = body.ErrorCode
+		pe.Message = body.Message
+	}
+	return pe
+}
+
+func (p *RPLogger) gPortalItem(apiPath, parent, id string, item interface{}) error {
 	url := fmt.Sprintf("/%s/%s", apiPath, id)
 	if parent != "" {
 		url = fmt.Sprintf("/%s/%s/%s", apiPath, parent, id)
 	}
-	_, err := p.requestWithAuth().
+	resp, err := p.requestWithAuth().
 		SetResult(item).
 		Get(url)
-	if err != nil {
-		panic(fmt.Errorf("Error:%w", err))
-	}
+	return checkResponse(http.MethodGet, url, resp, err)
 }
 
-func (p *RPLogger) uPortalItem(apiPath, parent, id string, item interface{}) {
+func (p *RPLogger) uPortalItem(apiPath, parent, id string, item interface{}) error {
 	url := fmt.Sprintf("/%s/%s", apiPath, id)
 	if parent != "" {
 		url = fmt.Sprintf("/%s/%s/%s", apiPath, parent, id)
 	}
-	_, err := p.requestWithAuth().
+	resp, err := p.requestWithAuth().
 		SetBody(item).
 		Put(url)
+	return checkResponse(http.MethodPut, url, resp, err)
+}
+
+func (p *RPLogger) cPortalItem(apiPath, parent string, item interface{}) error {
+	id, err := p.cAsyncPortalItem(apiPath, parent, item)
 	if err != nil {
-		panic(fmt.Errorf("Error:%w", err))
+		return err
 	}
+	return p.gPortalItem(apiPath, parent, id, item)
 }
 
-func (p *RPLogger) cPortalItem(apiPath, parent string, item interface{}) {
+func (p *RPLogger) cAsyncPortalItem(apiPath, parent string, item interface{}) (string, error) {
 	url := apiPath
 	if parent != "" {
 		url = fmt.Sprintf("/%s/%s", apiPath, parent)
 	}
-	resultID := &ResultID{}
-	_, err := p.requestWithAuth().
-		SetBody(item).
-		SetResult(&resultID).
-		Post(url)
+	uuid, err := p.newUUID()
 	if err != nil {
-		panic(fmt.Errorf("Error:%w", err))
+		return "", err
 	}
-	p.gPortalItem(apiPath, parent, resultID.ID, item)
-}
-
-func (p *RPLogger) cAsyncPortalItem(apiPath, parent string, item interface{}) string {
-	url := apiPath
-	if parent != "" {
-		url = fmt.Sprintf("/%s/%s", apiPath, parent)
+	if i, ok := item.(interface{ setUUID(uuid string) }); ok {
+		i.setUUID(uuid)
 	}
 	resultID := &ResultID{}
-	_, err := p.requestWithAuth().
+	resp, err := p.requestWithAuth().
 		SetBody(item).
 		SetResult(&resultID).
 		Post(url)
-	if err != nil {
-		panic(fmt.Errorf("Error:%w", err))
+	if err == nil && resp.StatusCode() == http.StatusConflict && resp.Request.Attempt > 1 {
+		// an earlier attempt did reach the portal, the retry was rejected as a duplicate of it
+		return uuid, nil
+	}
+	if err := checkResponse(http.MethodPost, url, resp, err); err != nil {
+		return "", err
+	}
+	if resultID.ID == "" {
+		return "", &PortalError{
+			Method: http.MethodPost, Path: url, StatusCode: resp.StatusCode(), Status: resp.Status(),
+			Err: errNoID,
+		}
 	}
 
-	return resultID.ID
+	return resultID.ID, nil
 }
 
 func NewRPLogger(client *resty.Client, token, project string) *RPLogger {
-	return &RPLogger{project: project, client: client, authToken: token}
+	return &RPLogger{
+		project: project, client: client, authToken: token, newUUID: randomUUID,
+		LaunchLogs: true, Batch: DefaultLogBatchOptions(), TestAttributePatterns: DefaultTestAttributePatterns,
+		journalEvery: time.Second,
+	}
 }
 
 func (p *RPLogger) getLaunch(name string) int {
@@ -167,105 +244,424 @@ func (p *RPLogger) getCase(name string) int {
 	if currentCase < 0 {
 		return -1
 	}
-	if p.Tests[currentCase].Name == name {
+	if p.Tests[currentCase].path == name {
 		return currentCase
 	}
 	for i, v := range p.Tests {
-		if v.Name == name {
+		if v.path == name {
 			return i
 		}
 	}
 	return -1
 }
 
-func toUnix(startTime string) int {
-	tt, err := time.Parse(time.RFC3339, startTime)
+// testItem looks up a started test, nil if there is none.
+func (p *RPLogger) testItem(name string) *RPItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i := p.getCase(name); i >= 0 {
+		return p.Tests[i]
+	}
+	return nil
+}
+
+func (p *RPLogger) finishSuite(endTime string) error {
+	t, err := toUnix(endTime)
+	if err != nil {
+		return err
+	}
+	if err := p.flushLogs(); err != nil {
+		return err
+	}
+	if err := p.finishParents(); err != nil {
+		return err
+	}
+	// the durations of the tests may reach past the last time in the log
+	p.mu.Lock()
+	for _, ts := range p.Tests {
+		if ts.EndTime > t {
+			t = ts.EndTime
+		}
+	}
+	p.mu.Unlock()
+	err = p.uPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", p.suite.UUID,
+		&RPItem{EndTime: t, LaunchUUID: p.launch.UUID})
 	if err != nil {
-		panic(fmt.Errorf("Error:%w", err))
+		return withItem(p.suite.Name, err)
 	}
-	return int(tt.Unix()) * 1000 // api needs millisecond instead of seconds
+	p.suite.EndTime = t
+	return nil
 }
 
-func (p *RPLogger) EnsureLaunch(name, suite, startTime string) {
+func (p *RPLogger) EnsureLaunch(name, suite, startTime string) error {
+	t, err := toUnix(startTime)
+	if err != nil {
+		return err
+	}
 	if p.getLaunch(name) < 0 {
-		l := &RPLaunch{Name: name, StartTime: toUnix(startTime), Rerun: false}
-		p.cPortalItem(fmt.Sprintf("api/v1/%s/launch", p.project), "", l)
+		l := &RPLaunch{Name: name, StartTime: t, Rerun: false, Attributes: p.Attributes}
+		if err := p.cPortalItem(fmt.Sprintf("api/v1/%s/launch", p.project), "", l); err != nil {
+			return withItem(name, err)
+		}
 		p.launch = l
 	}
 	if p.launch.UUID == "" {
-		p.gPortalItem(fmt.Sprintf("api/v1/%s/launch", p.project), "", string(p.launch.ID), p.launch)
+		err := p.gPortalItem(fmt.Sprintf("api/v1/%s/launch", p.project), "", string(p.launch.ID), p.launch)
+		if err != nil {
+			return withItem(name, err)
+		}
 	}
 	if p.getSuite(suite) < 0 {
-		s := &RPItem{Name: suite, Type: "suite", LaunchUUID: p.launch.UUID, StartTime: toUnix(startTime)}
+		if p.suite != nil {
+			// switching to another suite, i.e. next package of go test -json
+			if err := p.finishSuite(startTime); err != nil {
+				return err
+			}
+			p.mu.Lock()
+			p.Tests = nil
+			p.Steps = nil
+			p.mu.Unlock()
+		}
+		s := &RPItem{Name: suite, Type: "suite", LaunchUUID: p.launch.UUID, StartTime: t}
 		fmt.Println(s)
-		p.cPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", s)
+		if err := p.cPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", s); err != nil {
+			return withItem(suite, err)
+		}
 		p.suite = s
+		if err := p.writeJournal(); err != nil {
+			return err
+		}
 	}
 	if p.suite.UUID == "" {
-		p.gPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", string(p.suite.ID), p.suite)
+		return withItem(suite,
+			p.gPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", string(p.suite.ID), p.suite))
 	}
+	return nil
 }
 
-func (p *RPLogger) EnsureTest(name, startTime string) {
-	if p.getCase(name) >= 0 {
-		return
+// parentTest is the name of the parent of a go subtest like TestFoo/case_1, empty for a top-level test.
+func parentTest(name string) string {
+	if i := strings.LastIndex(name, "/"); i > 0 && i < len(name)-1 {
+		return name[:i]
+	}
+	return ""
+}
+
+func (p *RPLogger) EnsureTest(name, startTime string) error {
+	if p.testItem(name) != nil {
+		return nil
+	}
+	if p.launch == nil || p.suite == nil {
+		return errNoLaunch
+	}
+	t, err := toUnix(startTime)
+	if err != nil {
+		return err
 	}
-	t := toUnix(startTime)
 	uuid := p.launch.UUID
-	ts := &RPItem{Name: name, StartTime: t, Type: "test", LaunchUUID: uuid, Description: name}
-	ts.UUID = p.cAsyncPortalItem(fmt.Sprintf("api/v2/%s/item", p.project), p.suite.UUID, ts)
+	ts := &RPItem{Name: name, StartTime: t, Type: "test", LaunchUUID: uuid, Description: name, path: name,
+		Attributes: captureAttributes(p.TestAttributePatterns, name)}
+	parentUUID := p.suite.UUID
+	if parent := parentTest(name); parent != "" && !p.FlattenSubtests {
+		// subtests are nested in their parent, which is started first when its own run line is missing
+		if err := p.EnsureTest(parent, startTime); err != nil {
+			return err
+		}
+		ts.parent = p.testItem(parent)
+		ts.Name = name[len(parent)+1:]
+		ts.Type = "step"
+		parentUUID = ts.parent.UUID
+	}
+	ts.UUID, err = p.cAsyncPortalItem(fmt.Sprintf("api/v2/%s/item", p.project), parentUUID, ts)
+	if err != nil {
+		return withItem(name, err)
+	}
+	p.mu.Lock()
 	p.Tests = append(p.Tests, ts)
+	p.mu.Unlock()
+	return p.writeJournal()
+}
+
+// finishParents finishes the tests which were left open while their subtests finished,
+// i.e. when the output was cut before the --- FAIL line of the parent. Their status is derived from the subtests.
+func (p *RPLogger) finishParents() error {
+	p.mu.Lock()
+	tests := append([]*RPItem{}, p.Tests...)
+	p.mu.Unlock()
+	// subtests are started after their parent, going backwards derives the deepest parents first
+	for i := len(tests) - 1; i >= 0; i-- {
+		ts := tests[i]
+		if ts.status != "" {
+			continue
+		}
+		f := &RPFinishItem{LaunchUUID: ts.LaunchUUID, EndTime: ts.StartTime}
+		for _, c := range tests {
+			if c.parent != ts || c.status == "" {
+				continue
+			}
+			if c.status == "failed" || f.Status == "" || (f.Status == "skipped" && c.status == "passed") {
+				f.Status = c.status
+			}
+			if c.EndTime > f.EndTime {
+				f.EndTime = c.EndTime
+			}
+		}
+		if f.Status == "" {
+			continue
+		}
+		if err := p.finishItem(ts.path, ts.UUID, f); err != nil {
+			return err
+		}
+		ts.status, ts.EndTime = f.Status, f.EndTime
+	}
+	return nil
+}
+
+// finishItem sends the finish of a test or a step. The portal takes no logs for a finished item,
+// so its buffered log lines are uploaded first, as are the others once they waited long enough,
+// and the uploads in flight of the other workers are waited for.
+func (p *RPLogger) finishItem(name, uuid string, f *RPFinishItem) error {
+	p.mu.Lock()
+	pending := p.batch.holds(uuid)
+	p.mu.Unlock()
+	flush := p.flushStale
+	if pending {
+		flush = p.flushLogs
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	// taking the lock only waits for the uploads
+	p.uploads.Lock()
+	p.uploads.Unlock()
+	return withItem(name, p.uPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", uuid, f))
+}
+
+// logItem is the uuid of the item to log to for the test, its open step or itself,
+// or none for the launch when the test is finished already, i.e. the PASS line printed after the last test.
+func (p *RPLogger) logItem(name string) string {
+	ts := p.testItem(name)
+	if step := p.openStep(name); step != nil {
+		return step.UUID
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ts.status != "" {
+		return ""
+	}
+	return ts.UUID
 }
 
-func (p *RPLogger) AddLine(name, startTime, level, message string) {
+func (p *RPLogger) AddLine(name, startTime, level, message string) error {
 	fmt.Printf("LOG: %s %s %s %s", name, startTime, level, message)
-	p.EnsureTest(name, startTime)
-	currentCase := p.getCase(name)
-	fmt.Printf("LOG:CASE %v", currentCase)
+	if err := p.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	item := p.logItem(name)
+	fmt.Printf("LOG:CASE %v", name)
+	uuid, err := p.newUUID()
+	if err != nil {
+		return err
+	}
 	l := &RPLog{
 		LaunchUUID: p.launch.UUID,
-		ItemUUID:   p.Tests[currentCase].UUID,
+		ItemUUID:   item,
 		Time:       startTime,
 		Message:    message,
 		Level:      level,
+		UUID:       uuid,
 	}
 	fmt.Printf("LOG:CASE %v", l)
-	p.cAsyncPortalItem(fmt.Sprintf("api/v2/%s/log/entry", p.project), "", l)
-	l.ItemUUID = ""
-	p.cAsyncPortalItem(fmt.Sprintf("api/v2/%s/log/entry", p.project), "", l)
+	var launchLog *RPLog
+	if p.LaunchLogs && item != "" {
+		launchLog = &RPLog{}
+		*launchLog = *l
+		launchLog.ItemUUID = ""
+		if launchLog.UUID, err = p.newUUID(); err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	p.batch.add(l)
+	if launchLog != nil {
+		p.batch.add(launchLog)
+	}
+	var logs []*RPLog
+	var waiting *Journal
+	if p.batch.full(p.Batch) {
+		logs, waiting = p.takeLogs()
+	}
+	p.mu.Unlock()
+	return p.uploadLogs(logs, waiting)
 }
 
-func (p *RPLogger) FinnishTest(name, startTime, result, t string) {
-	value, err := strconv.ParseFloat(t, 32)
+func (p *RPLogger) FinnishTest(name, startTime, result, t string) error {
+	value, err := strconv.ParseFloat(t, 64)
 	if err != nil {
-		panic(fmt.Errorf("Error:%w", err))
+		return &InputError{Field: "duration", Value: t, Err: err}
 	}
 
-	p.EnsureTest(name, startTime)
-	currentCase := p.getCase(name)
-
-	ts := p.Tests[currentCase]
+	if err := p.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	ts := p.testItem(name)
+	p.mu.Lock()
+	finished := ts.status != ""
+	p.mu.Unlock()
+	if finished {
+		// finished before the upload was interrupted
+		return nil
+	}
 	f := &RPFinishItem{
-		EndTime:    ts.StartTime + int(value)*1000,
+		EndTime:    ts.StartTime + int(math.Round(value*1000)),
 		LaunchUUID: ts.LaunchUUID,
-		Status:     "passed",
+		Status:     rpStatus(result),
+	}
+	// kuttl does not complete the step it failed in, it fails together with the test
+	if step := p.openStep(name); step != nil {
+		endTime := f.EndTime
+		if endTime < step.StartTime {
+			endTime = step.StartTime
+		}
+		if err := p.finishStep(name, step, endTime, result); err != nil {
+			return err
+		}
+	}
+	// the subtests are finished before their parent, which ends no sooner than them
+	p.mu.Lock()
+	for _, c := range p.Tests {
+		if c.parent == ts && c.EndTime > f.EndTime {
+			f.EndTime = c.EndTime
+		}
+	}
+	p.mu.Unlock()
+	if err := p.finishItem(ts.path, ts.UUID, f); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	ts.status, ts.EndTime = f.Status, f.EndTime
+	p.mu.Unlock()
+	return p.writeJournal()
+}
+
+func rpStatus(result string) string {
+	switch result {
+	case "FAIL":
+		return "failed"
+	case "SKIP":
+		return "skipped"
+	}
+	return "passed"
+}
+
+// stepItem looks up a started step of the test, nil if there is none.
+func (p *RPLogger) stepItem(name, step string) *RPItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.Steps[name] {
+		if s.Name == step {
+			return s
+		}
+	}
+	return nil
+}
+
+// openStep is the step of the test that was started and not finished yet, nil if there is none.
+func (p *RPLogger) openStep(name string) *RPItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	steps := p.Steps[name]
+	if len(steps) == 0 || steps[len(steps)-1].EndTime != 0 {
+		return nil
+	}
+	return steps[len(steps)-1]
+}
+
+func (p *RPLogger) EnsureStep(name, step, startTime string) error {
+	if err := p.EnsureTest(name, startTime); err != nil {
+		return err
 	}
-	if result == "FAIL" {
-		f.Status = "failed"
+	if p.stepItem(name, step) != nil {
+		return nil
 	}
-	if result == "SKIP" {
-		f.Status = "skipped"
+	t, err := toUnix(startTime)
+	if err != nil {
+		return err
+	}
+	if open := p.openStep(name); open != nil {
+		if err := p.finishStep(name, open, t, "PASS"); err != nil {
+			return err
+		}
+	}
+	ts := p.testItem(name)
+	s := &RPItem{Name: step, StartTime: t, Type: "step", LaunchUUID: ts.LaunchUUID, Description: step}
+	s.UUID, err = p.cAsyncPortalItem(fmt.Sprintf("api/v2/%s/item", p.project), ts.UUID, s)
+	if err != nil {
+		return withItem(name+"/"+step, err)
+	}
+	p.mu.Lock()
+	if p.Steps == nil {
+		p.Steps = map[string][]*RPItem{}
+	}
+	p.Steps[name] = append(p.Steps[name], s)
+	p.mu.Unlock()
+	return p.writeJournal()
+}
+
+func (p *RPLogger) FinnishStep(name, step, endTime, result string) error {
+	if err := p.EnsureStep(name, step, endTime); err != nil {
+		return err
 	}
-	p.uPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", ts.UUID, f)
+	t, err := toUnix(endTime)
+	if err != nil {
+		return err
+	}
+	s := p.stepItem(name, step)
+	if s.EndTime != 0 {
+		return nil
+	}
+	return p.finishStep(name, s, t, result)
 }
 
-func (p *RPLogger) Finish(t string) {
+func (p *RPLogger) finishStep(name string, s *RPItem, endTime int, result string) error {
+	f := &RPFinishItem{EndTime: endTime, LaunchUUID: s.LaunchUUID, Status: rpStatus(result)}
+	if err := p.finishItem(name+"/"+s.Name, s.UUID, f); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	s.EndTime = endTime
+	p.mu.Unlock()
+	return p.writeJournal()
+}
+
+func (p *RPLogger) Finish(t string) error {
+	if p.launch == nil {
+		return errNoLaunch
+	}
+	endTime, err := toUnix(t)
+	if err != nil {
+		return err
+	}
+	if err := p.flushLogs(); err != nil {
+		return err
+	}
 	if p.suite != nil {
-		p.uPortalItem(fmt.Sprintf("api/v1/%s/item", p.project), "", p.suite.UUID,
-			&RPItem{EndTime: toUnix(t), LaunchUUID: p.launch.UUID})
+		if err := p.finishSuite(t); err != nil {
+			return err
+		}
+		if p.suite.EndTime > endTime {
+			endTime = p.suite.EndTime
+		}
+	}
+	// the portal merges the attributes sent on finish with the ones the launch was started with
+	attrs := mergeAttributes(p.Attributes, p.Scanner.Attributes()...)
+	err = p.uPortalItem(fmt.Sprintf("api/v1/%s/launch", p.project), p.launch.UUID,
+		"finish", &RPItem{EndTime: endTime, Attributes: attrs})
+	if err != nil {
+		return withItem(p.launch.Name, err)
 	}
-	p.uPortalItem(fmt.Sprintf("api/v1/%s/launch", p.project), p.launch.UUID, "finish",
-		&RPItem{EndTime: toUnix(t)})
+	p.progress.Finished = true
+	return p.writeJournal()
 }
 
 func getMatches(re *regexp.Regexp, str string) map[string]string {
@@ -289,6 +685,15 @@ type Lines interface {
 	reLOG() string
 	rePAUSE() string
 	reCONT() string
+	reSTEP() string
+	reSTEPEND() string
+	rePANIC() string
+	rePANICCONT() string
+	reTRACE() string
+	reTRACECONT() string
+	reDIFF() string
+	reDIFFCONT() string
+	reENDCONT() string
 }
 
 type DefaultLines struct{}
@@ -301,8 +706,13 @@ func (l *DefaultLines) reEND() string {
 	return `^.*--- (?P<result>\w+): (?:kuttl/harness/)?(?P<test>[\w/\-_]+)\W*\((?P<duration>\w+\.?\w*)s.*$`
 }
 
+// reENDCONT continues the result of a test with the indented results of its subtests.
+func (l *DefaultLines) reENDCONT() string {
+	return `^\s+--- (?:PASS|FAIL|SKIP): `
+}
+
 func (l *DefaultLines) reSTAMP() string {
-	return `^.*startTime.*"(?P<startDate>[0-9-:]*)T.*"`
+	return `^.*startTime.*"(?P<lastTime>(?P<startDate>[0-9-:]*)T[^"]*)"`
 }
 
 func (l *DefaultLines) reRUN() string {
@@ -310,208 +720,618 @@ func (l *DefaultLines) reRUN() string {
 }
 
 func (l *DefaultLines) reLOG() string {
-	argo := `time="(?P<date>[0-9-:]*)T(?P<timestamp>\d\d:\d\d:\d\d)Z".*level=(?P<level>\w+).*msg="(?P<msg>.*)".*`
-	kuttl := `.*logger.*(?P<timestamp>\d\d:\d\d:\d\d) \| (?P<test>[\w-_]*)/?(?P<step>[\w-_]*)? \|(?P<msg>.*)`
+	argo := `time="(?P<date>[0-9-:]*)T(?P<timestamp>\d\d:\d\d:\d\d(?:\.\d+)?)Z".*level=(?P<level>\w+).*msg="(?P<msg>.*)".*`
+	kuttl := `.*logger.*(?P<timestamp>\d\d:\d\d:\d\d(?:\.\d+)?) \| (?P<test>[\w-_]*)/?(?P<step>[\w-_]*)? \|(?P<msg>.*)`
 	return fmt.Sprintf(`(?:^%s$|^%s$)`, argo, kuttl)
 }
 
+func (l *DefaultLines) reSTEP() string {
+	return `^.*logger.*(?P<timestamp>\d\d:\d\d:\d\d(?:\.\d+)?) \| (?P<test>[\w-_]*)/(?P<step>[\w-_]*) \|` +
+		`(?P<msg> starting test step .*)$`
+}
+
+func (l *DefaultLines) reSTEPEND() string {
+	return `^.*logger.*(?P<timestamp>\d\d:\d\d:\d\d(?:\.\d+)?) \| (?P<test>[\w-_]*)/(?P<step>[\w-_]*) \|` +
+		`(?P<msg> test step (?P<result>completed|failed).*)$`
+}
+
+// rePANIC starts a go panic, its goroutine dumps follow.
+func (l *DefaultLines) rePANIC() string {
+	return `^(?P<line>panic: .*)$`
+}
+
+func (l *DefaultLines) rePANICCONT() string {
+	return `^(?:\s*$|\t|goroutine \d+ \[|\[signal |created by |panic: |[\w./*()-]+\(.*\)$)`
+}
+
+// reTRACE starts the output of a failed testify assertion, the file:line header is followed by a tab-indented block.
+func (l *DefaultLines) reTRACE() string {
+	return `^(?P<line> +[\w.-]+\.go:\d+: ?)$`
+}
+
+func (l *DefaultLines) reTRACECONT() string {
+	return `^ +\t`
+}
+
+// reDIFF starts the diff kuttl prints for a resource that does not match the assert, indented under the case.go line.
+func (l *DefaultLines) reDIFF() string {
+	return `^(?P<line> +case\.go:\d+: --- .*)$`
+}
+
+func (l *DefaultLines) reDIFFCONT() string {
+	return `^ {8}(?:\+\+\+ |@@ |[ +]|-[^-]|-?$)`
+}
+
 func (l *DefaultLines) rePAUSE() string {
 	return `^=== PAUSE\W*(?:kuttl/harness/)?(?P<test>[\w/\-_]*)/?(?P<step>[\w-_]*)?.*$`
 }
 
+// Action is run with the current state and the captures of the matched pattern, returning the new state.
+type Action func(s, m map[string]string) (map[string]string, error)
+
 type PatternActions struct {
 	pattern *regexp.Regexp
-	actions []func(s, m map[string]string) map[string]string
+	actions []Action
+	// continuation and terminator make the pattern start a block of lines,
+	// its actions run once the block is over with the lines joined in the block capture
+	continuation *regexp.Regexp
+	terminator   *regexp.Regexp
+}
+
+func (pa *PatternActions) isBlock() bool {
+	return pa.continuation != nil || pa.terminator != nil
+}
+
+// openBlock is a block of lines being accumulated, with the captures of its first line.
+type openBlock struct {
+	pa       *PatternActions
+	captures map[string]string
+	lines    []string
 }
 
 type StateMachine struct {
 	state            map[string]string
 	patternToActions []*PatternActions
-	noErrors         bool
+	// open is the block being accumulated, nil outside of blocks
+	open *openBlock
 }
 
-func mkMachine(initialState map[string]string, noErrors bool) *StateMachine {
-	return &StateMachine{state: initialState, patternToActions: []*PatternActions{}, noErrors: noErrors}
+func mkMachine(initialState map[string]string) *StateMachine {
+	return &StateMachine{state: initialState, patternToActions: []*PatternActions{}}
 }
 
-func (m *StateMachine) pattern(r string, a ...func(s, m map[string]string) map[string]string) *StateMachine {
+func (m *StateMachine) pattern(r string, a ...Action) *StateMachine {
 	rx := regexp.MustCompile(r)
 
 	m.patternToActions = append(m.patternToActions, &PatternActions{pattern: rx, actions: a})
 	return m
 }
 
-func (m *StateMachine) feed(line string) {
+// block adds a pattern starting a block, which goes on while the lines match the continuation
+// and ends with the first line matching the terminator. Either of them can be empty.
+func (m *StateMachine) block(start, continuation, terminator string, a ...Action) *StateMachine {
+	pa := &PatternActions{pattern: regexp.MustCompile(start), actions: a}
+	if continuation != "" {
+		pa.continuation = regexp.MustCompile(continuation)
+	}
+	if terminator != "" {
+		pa.terminator = regexp.MustCompile(terminator)
+	}
+	m.patternToActions = append(m.patternToActions, pa)
+	return m
+}
+
+func (m *StateMachine) run(actions []Action, mt map[string]string) error {
+	for _, f := range actions {
+		s, err := f(m.state, mt)
+		if err != nil {
+			return err
+		}
+		m.state = s
+	}
+	return nil
+}
+
+// flush runs the actions of the open block, if there is one.
+func (m *StateMachine) flush() error {
+	b := m.open
+	if b == nil {
+		return nil
+	}
+	m.open = nil
+	b.captures["block"] = strings.TrimRight(strings.Join(b.lines, "\n"), " \t\n")
+	return m.run(b.pa.actions, b.captures)
+}
+
+// feed runs the actions of the first pattern matching the line, stopping at the first failing one.
+// Lines continuing an open block are added to it instead, a line ending the block runs the actions of the block
+// before its own.
+func (m *StateMachine) feed(line string) error {
+	var errBlock error
+	if b := m.open; b != nil {
+		if b.pa.terminator != nil && b.pa.terminator.MatchString(line) {
+			b.lines = append(b.lines, line)
+			return m.flush()
+		}
+		if b.pa.continuation != nil && b.pa.continuation.MatchString(line) {
+			b.lines = append(b.lines, line)
+			return nil
+		}
+		errBlock = m.flush()
+	}
 	for _, pa := range m.patternToActions {
 		if mt := getMatches(pa.pattern, line); len(mt) > 0 {
-			for _, f := range pa.actions {
-				func() {
-					defer func() {
-						if m.noErrors {
-							if r := recover(); r != nil {
-								fmt.Printf("Recovered in f - %v\n", r)
-							}
-						}
-					}()
-					m.state = f(m.state, mt)
-				}()
+			if pa.isBlock() {
+				m.open = &openBlock{pa: pa, captures: mt, lines: []string{line}}
+				return errBlock
+			}
+			if err := m.run(pa.actions, mt); err != nil && errBlock == nil {
+				errBlock = err
 			}
-			return
+			return errBlock
 		}
 	}
+	return errBlock
 }
 
-func mapCopy(dst, src map[string]string) map[string]string {
+func mapCopy(dst, src map[string]string) (map[string]string, error) {
 	maps.Copy(dst, src)
-	return dst
+	return dst, nil
 }
 
 type TestReportBuilder interface {
 	getLaunch(name string) int
 	getCase(name string) int
-	EnsureTest(name, startTime string)
-	AddLine(name, startTime, level, message string)
-	FinnishTest(name, startTime, result, time string)
-	Finish(time string)
-	EnsureLaunch(name, suite, startTime string)
-}
-
-func processLinear(lg TestReportBuilder, launchName, suiteName string, filePipe *script.Pipe, noErrors bool) {
-	r := &DefaultLines{}
-	m := mkMachine(map[string]string{"test": "", "level": "", "startDate": "", "time": "", "launch": ""},
-		noErrors).
-		pattern(r.reSTAMP(), mapCopy).
-		pattern(r.reCONT(), mapCopy).
-		pattern(r.rePAUSE(), mapCopy).
-		pattern(r.reRUN(), mapCopy).
-		pattern(r.reLOG(), mapCopy,
-			func(s, m map[string]string) map[string]string {
-				if s["test"] != "" {
-					s["time"] = fmt.Sprintf("%s%sT%sZ", s["startDate"], m["date"], m["timestamp"])
-					lg.EnsureLaunch(launchName, suiteName, s["time"])
-					lg.AddLine(s["test"], s["time"], s["level"], m["msg"])
-				}
-				return s
-			}).pattern(r.reEND(),
-		mapCopy,
-		func(s, m map[string]string) (o map[string]string) {
-			if s["test"] != "" {
-				lg.FinnishTest(s["test"], s["time"], m["result"], m["duration"])
+	EnsureTest(name, startTime string) error
+	AddLine(name, startTime, level, message string) error
+	FinnishTest(name, startTime, result, time string) error
+	Finish(time string) error
+	EnsureLaunch(name, suite, startTime string) error
+	// EnsureStep starts the step of the test unless it was started already, finishing the previous one as passed.
+	// Log lines of the test go to its open step until FinnishStep or FinnishTest.
+	EnsureStep(name, step, startTime string) error
+	FinnishStep(name, step, endTime, result string) error
+	// Attach uploads the file as a log of the test, or of its open step.
+	Attach(name, startTime, path string) error
+}
+
+// ParseOptions control how the input log is turned into TestReportBuilder calls.
+type ParseOptions struct {
+	Format   string
+	Grammar  *Grammar
+	NoErrors bool
+	// Attributes scans every line of the input for launch attributes, nil when there are no patterns
+	Attributes *AttributeScanner
+	// Location is the time zone of the times without one, UTC when nil
+	Location *time.Location
+	// StartTime is the time of the lines before the first one with a time,
+	// taken from the StartTimeFrom sources when zero
+	StartTime time.Time
+	// StartTimeFrom are the sources of the start time tried in order, the ones of DefaultStartTimeFrom when nil
+	StartTimeFrom []string
+	// FileTime is the modification time of the log file, zero for stdin
+	FileTime time.Time
+	// Resume continues an interrupted upload of a text log after the lines reported according to its journal
+	Resume *Journal
+	// Checkpoint is told about every line which is parsed, together with the state of the parser after it
+	Checkpoint func(line int, state map[string]string) error
+	// Redactor masks the secrets of the lines echoed and scanned for attributes, nil keeps them
+	Redactor *Redactor
+	// Echo is where the lines of a text or go test -json log are echoed to while they are parsed, stdout when nil
+	Echo io.Writer
+}
+
+func (o *ParseOptions) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+func (o *ParseOptions) echo() io.Writer {
+	if o.Echo == nil {
+		return os.Stdout
+	}
+	return o.Echo
+}
+
+// mask hides the secrets of a line of the input, the reported lines are masked by the redactFilter.
+func (o *ParseOptions) mask(line string) string {
+	if o.Redactor == nil {
+		return line
+	}
+	return o.Redactor.mask(line)
+}
+
+func processLinear(lg TestReportBuilder, launchName, suiteName string, filePipe *script.Pipe, opts *ParseOptions,
+) error {
+	g := opts.Grammar
+	if g == nil {
+		g = DefaultGrammar()
+	}
+	start := opts.StartTime
+	if start.IsZero() {
+		var err error
+		if start, filePipe, err = startOf(filePipe, opts.location(), opts.StartTimeFrom, opts.FileTime); err != nil {
+			return err
+		}
+	}
+	m := g.machine(lg, launchName, suiteName, opts.location(), start)
+	skip := 0
+	if opts.Resume != nil {
+		skip = opts.Resume.Line
+		maps.Copy(m.state, opts.Resume.State)
+	}
+	report := &ErrorReport{noErrors: opts.NoErrors}
+	lineNo := 0
+	stopped := false
+	_, errPipe := filePipe.FilterLine(func(line string) string {
+		lineNo++
+		if !stopped && lineNo > skip {
+			if err := m.feed(line); err != nil {
+				stopped = !report.addLine(lineNo, err)
 			}
-			return s
-		},
-	).pattern("(?P<line>^.*$)",
-		func(s, m map[string]string) map[string]string {
-			if s["test"] != "" && s["time"] != "" {
-				lg.EnsureTest(s["test"], s["time"])
-				lg.AddLine(s["test"], s["time"], s["level"], m["line"])
+			// a block is only reported once it ends
+			if !stopped && opts.Checkpoint != nil && m.open == nil {
+				if err := opts.Checkpoint(lineNo, m.state); err != nil {
+					stopped = !report.add(err)
+				}
 			}
-			return s
-		},
-	)
-	_, errPipe := filePipe.FilterLine(func(line string) string {
-		m.feed(line)
-		return line
-	}).Stdout()
+		}
+		return opts.mask(line)
+	}).WithStdout(opts.echo()).Stdout()
 	if errPipe != nil {
-		fmt.Println(errPipe)
+		report.add(errPipe)
 	}
-	lg.Finish(m.state["time"])
+	if !stopped {
+		if err := m.flush(); err != nil {
+			stopped = !report.addLine(lineNo, err)
+		}
+	}
+	if !stopped && lg.getLaunch(launchName) >= 0 {
+		report.add(lg.Finish(m.state["time"]))
+	}
+	return report.err()
 }
 
-func firstLaunchIDWithName(client *resty.Client, token, portalURL, project, reportName string) string {
-	url := fmt.Sprintf("%s/api/v1/%s/launch?filter.eq.name=%s", portalURL, project, reportName)
+// getJSONID runs the query against the portal, returning the id of the first result or nullResult.
+func getJSONID(client *resty.Client, token, url string) (string, error) {
 	fmt.Println(url)
-	r, err := http.NewRequestWithContext(context.Background(), "GET", url, http.NoBody)
-	if err != nil {
-		panic(err)
+	result := &Launches{}
+	resp, err := client.R().
+		SetAuthToken(token).
+		SetResult(result).
+		Get(url)
+	if err := checkResponse(http.MethodGet, url, resp, err); err != nil {
+		return "", err
 	}
-	r.Header.Add("Authorization", "Bearer "+token)
-	c, err := script.NewPipe().WithHTTPClient(client.GetClient()).
-		Do(r).JQ(".content[0].id").String()
-	if err != nil {
-		panic(err)
+	if len(result.Content) == 0 {
+		return nullResult, nil
 	}
-	return strings.TrimSpace(c)
+	return string(result.Content[0].ID), nil
 }
 
-func firstSuiteIDWithName(client *resty.Client, token, portalURL, project, launchID, suiteName string) string {
+func firstLaunchIDWithName(client *resty.Client, token, portalURL, project, reportName string) (string, error) {
+	url := fmt.Sprintf("%s/api/v1/%s/launch?filter.eq.name=%s", portalURL, project, reportName)
+	return getJSONID(client, token, url)
+}
+
+func firstSuiteIDWithName(client *resty.Client, token, portalURL, project, launchID, suiteName string,
+) (string, error) {
 	url := fmt.Sprintf("%s/api/v1/%s/item?filter.eq.launchId=%s&filter.eq.name=%s",
 		portalURL, project, launchID, suiteName)
-	fmt.Println(url)
+	return getJSONID(client, token, url)
+}
 
-	r, err := http.NewRequestWithContext(context.Background(), "GET", url, http.NoBody)
-	if err != nil {
-		panic(err)
+const nullResult = "null"
+
+// UploadOptions are the settings of a single upload, as given on the command line.
+type UploadOptions struct {
+	ConnectionOptions
+	Launch       string
+	Suite        string
+	LogFile      string
+	Format       string
+	GrammarFile  string
+	SkipExisting bool
+	IgnoreErrors bool
+	LaunchLogs   bool
+	LogBatch     LogBatchOptions
+	Concurrency  int
+	DryRun       bool
+	// PrintJSON prints the report of a dry run as json instead of a tree
+	PrintJSON bool
+	Outputs   OutputFlag
+	// FlattenSubtests keeps go subtests as siblings of their parent
+	FlattenSubtests bool
+	Attributes      AttrFlag
+	// AttributePatterns capture launch attributes from the log, TestAttributePatterns from the test names
+	AttributePatterns     PatternFlag
+	TestAttributePatterns PatternFlag
+	// AttachmentDir holds files to attach to the tests matched by AttachmentPattern
+	AttachmentDir     string
+	AttachmentPattern string
+	// LevelsFile extends the default mapping of log levels, lines below MinLevel are dropped
+	LevelsFile string
+	MinLevel   string
+	// TZ is the time zone of the logs written in local time, i.e. Europe/Prague
+	TZ string
+	// StartTime is the time of the log lines before the first one with a time,
+	// taken from the comma separated StartTimeFrom sources when empty
+	StartTime     string
+	StartTimeFrom string
+	// Journal is where the progress of the upload is written, Resume is the journal of an interrupted upload
+	// to continue, it is written to as well unless Journal says otherwise
+	Journal string
+	Resume  string
+	// RedactFile has more patterns of secrets to mask in the log lines
+	RedactFile string
+}
+
+// needsToken tells whether the options lead to talking to the portal.
+func (o *UploadOptions) needsToken() bool {
+	if o.DryRun {
+		return false
 	}
-	r.Header.Add("Authorization", "Bearer "+token)
-	c, err := script.NewPipe().WithHTTPClient(client.GetClient()).
-		Do(r).JQ(".content[0].id").String()
+	outputs, err := parseOutputs(o.Outputs)
 	if err != nil {
-		panic(err)
+		// run reports the bad output
+		return false
 	}
-	return strings.TrimSpace(c)
+	for _, out := range outputs {
+		if out.Kind == outputPortal {
+			return true
+		}
+	}
+	return false
 }
 
-const nullResult = "null"
-
-func run(portalURL, token, reportProject, reportName, suiteName, logFile string, skipTLS, skipExisting, noErrors bool) {
-	client := resty.New()
-	client.SetBaseURL(portalURL)
-	client.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: skipTLS})
-	client.SetAuthToken(token)
-
-	lg := NewRPLogger(client, token, reportProject)
+// portalLogger prepares the upload to the portal,
+// returning nil when the suite is already reported and should be skipped.
+func portalLogger(o *UploadOptions, scanner *AttributeScanner, resume *Journal) (*RPLogger, error) {
+	client, err := o.client()
+	if err != nil {
+		return nil, err
+	}
+	lg := NewRPLogger(client, o.Token, o.Project)
+	lg.LaunchLogs = o.LaunchLogs
+	lg.Batch = o.LogBatch
+	lg.FlattenSubtests = o.FlattenSubtests
+	lg.Attributes = o.Attributes
+	lg.Scanner = scanner
+	if len(o.TestAttributePatterns) > 0 {
+		lg.TestAttributePatterns = o.TestAttributePatterns
+	}
+	lg.JournalPath = o.Journal
+	if resume != nil {
+		lg.resume(resume)
+		return lg, nil
+	}
 
-	lid := firstLaunchIDWithName(client, token, portalURL, reportProject, reportName)
+	lid, err := firstLaunchIDWithName(client, o.Token, o.PortalURL, o.Project, o.Launch)
+	if err != nil {
+		return nil, err
+	}
 	if lid != nullResult {
-		sid := firstSuiteIDWithName(client, token, portalURL, reportProject, lid, suiteName)
+		sid, err := firstSuiteIDWithName(client, o.Token, o.PortalURL, o.Project, lid, o.Suite)
+		if err != nil {
+			return nil, err
+		}
 
-		if (sid != nullResult) && skipExisting {
-			fmt.Printf("Suite %s in launch %s already reported\n", suiteName, reportName)
-			return
+		if (sid != nullResult) && o.SkipExisting {
+			fmt.Printf("Suite %s in launch %s already reported\n", o.Suite, o.Launch)
+			return nil, nil
 		}
 
 		// we are uploading new suite to existing launch, so we should pre-fill the launch
-		lg.launch = &RPLaunch{Name: reportName, ID: json.Number(lid), UUID: ""}
+		lg.launch = &RPLaunch{Name: o.Launch, ID: json.Number(lid), UUID: ""}
 		if sid != nullResult {
-			lg.suite = &RPItem{Name: suiteName, ID: json.Number(sid), UUID: ""}
+			lg.suite = &RPItem{Name: o.Suite, ID: json.Number(sid), UUID: ""}
+		}
+	}
+	return lg, nil
+}
+
+// startTime is the -startTime of the log, zero for the one of the -startTimeFrom sources.
+func (o *UploadOptions) startTime(loc *time.Location) (time.Time, error) {
+	if o.StartTime == "" {
+		return time.Time{}, nil
+	}
+	return parseTime(o.StartTime, loc)
+}
+
+// fileTime is the modification time of the log file, one of the -startTimeFrom sources. It is zero for stdin.
+func (o *UploadOptions) fileTime() (time.Time, error) {
+	if o.LogFile == "-" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(o.LogFile)
+	if err != nil {
+		return time.Time{}, &InputError{Field: "log file", Value: o.LogFile, Err: err}
+	}
+	return info.ModTime(), nil
+}
+
+// defaultNames names the launch and the suite after the ones of the resumed upload, which can not be renamed,
+// or after the time of the run.
+func (o *UploadOptions) defaultNames(resume *Journal) error {
+	if resume != nil {
+		if o.Launch != "" && o.Launch != resume.Launch.Name {
+			return &InputError{Field: "launch", Value: o.Launch,
+				Err: fmt.Errorf("-resume goes on with launch %s", resume.Launch.Name)}
+		}
+		if o.Suite != "" && o.Suite != resume.Suite.Name {
+			return &InputError{Field: "name", Value: o.Suite,
+				Err: fmt.Errorf("-resume goes on with suite %s", resume.Suite.Name)}
+		}
+		o.Launch, o.Suite = resume.Launch.Name, resume.Suite.Name
+	}
+	name := fmt.Sprintf("run%s", time.Now().Format("20060102150405"))
+	if o.Launch == "" {
+		o.Launch = name
+	}
+	if o.Suite == "" {
+		o.Suite = name
+	}
+	return nil
+}
+
+func run(o *UploadOptions) error {
+	var err error
+	parseOpts := &ParseOptions{Format: o.Format, NoErrors: o.IgnoreErrors}
+	if o.DryRun {
+		// stdout is for the report, i.e. the json of parse -json
+		parseOpts.Echo = os.Stderr
+	}
+	if o.TZ != "" {
+		loc, err := time.LoadLocation(o.TZ)
+		if err != nil {
+			return &InputError{Field: "tz", Value: o.TZ, Err: err}
+		}
+		parseOpts.Location = loc
+	}
+	if parseOpts.StartTime, err = o.startTime(parseOpts.location()); err != nil {
+		return err
+	}
+	if parseOpts.FileTime, err = o.fileTime(); err != nil {
+		return err
+	}
+	if parseOpts.StartTimeFrom, err = parseStartTimeFrom(o.StartTimeFrom); err != nil {
+		return err
+	}
+	if o.Resume != "" {
+		if parseOpts.Resume, err = loadJournal(o.Resume); err != nil {
+			return err
+		}
+		if o.Journal == "" {
+			o.Journal = o.Resume
+		}
+	}
+	if err := o.defaultNames(parseOpts.Resume); err != nil {
+		return err
+	}
+	if o.Journal != "" && o.Concurrency > 1 {
+		return &InputError{Field: "journal", Value: o.Journal,
+			Err: fmt.Errorf("the progress can only be followed with -concurrency 1")}
+	}
+	if o.GrammarFile != "" {
+		g, err := loadGrammar(o.GrammarFile)
+		if err != nil {
+			return err
+		}
+		parseOpts.Grammar = g
+	}
+	if len(o.AttributePatterns) > 0 {
+		parseOpts.Attributes = &AttributeScanner{Patterns: o.AttributePatterns}
+	}
+	outputs, err := parseOutputs(o.Outputs)
+	if err != nil {
+		return err
+	}
+	mapper := DefaultLevelMapper()
+	if o.LevelsFile != "" {
+		if mapper, err = loadLevelMapper(o.LevelsFile); err != nil {
+			return err
+		}
+	}
+	levels, err := newLevelFilter(nil, mapper, o.MinLevel)
+	if err != nil {
+		return err
+	}
+	redactor := DefaultRedactor()
+	if o.RedactFile != "" {
+		if redactor, err = loadRedactor(o.RedactFile); err != nil {
+			return err
+		}
+	}
+	parseOpts.Redactor = redactor
+	var attachments *attachmentDir
+	if o.AttachmentDir != "" {
+		// the sinks are wrapped once they are ready, the pattern is checked before anything is uploaded
+		if attachments, err = newAttachmentDir(nil, o.AttachmentDir, o.AttachmentPattern); err != nil {
+			return err
 		}
 	}
 
-	if logFile == "-" {
-		processLinear(lg, reportName, suiteName, script.Stdin(), noErrors)
-	} else {
-		processLinear(lg, reportName, suiteName, script.File(logFile), noErrors)
+	sinks := MultiBuilder{}
+	var tree *ReportTree
+	var async *asyncBuilder
+	for _, out := range outputs {
+		switch out.Kind {
+		case outputPortal:
+			if o.DryRun {
+				tree = NewReportTree()
+				tree.FlattenSubtests = o.FlattenSubtests
+				sinks = append(sinks, tree)
+				continue
+			}
+			lg, err := portalLogger(o, parseOpts.Attributes, parseOpts.Resume)
+			if err != nil {
+				return err
+			}
+			if lg == nil {
+				return nil
+			}
+			if o.Concurrency <= 1 {
+				parseOpts.Checkpoint = lg.Checkpoint
+				sinks = append(sinks, lg)
+				continue
+			}
+			async = newAsyncBuilder(lg, o.Concurrency, o.IgnoreErrors)
+			sinks = append(sinks, async)
+		case outputJSON:
+			sinks = append(sinks, NewJSONReport(out.Path))
+		case outputJUnit:
+			sinks = append(sinks, NewJUnitReport(out.Path))
+		}
+	}
+	var lg TestReportBuilder = sinks
+	if len(sinks) == 1 {
+		lg = sinks[0]
+	}
+	if attachments != nil {
+		attachments.TestReportBuilder = lg
+		lg = attachments
+	}
+	roots := []string{}
+	if o.LogFile != "-" {
+		roots = append(roots, filepath.Dir(o.LogFile))
+	}
+	if o.AttachmentDir != "" {
+		roots = append(roots, o.AttachmentDir)
 	}
+	if lg, err = newAttachmentRoots(lg, roots...); err != nil {
+		return err
+	}
+	levels.TestReportBuilder = lg
+	lg = &redactFilter{TestReportBuilder: levels, redactor: redactor}
+
+	filePipe := script.Stdin()
+	if o.LogFile != "-" {
+		filePipe = script.File(o.LogFile)
+	}
+	err = process(lg, o.Launch, o.Suite, filePipe, parseOpts)
+	if async != nil {
+		err = mergeErrors(async.Close(), err)
+	}
+	if tree != nil && o.PrintJSON {
+		// the json is kept apart from the report of the redactions, so that it can be piped on
+		redactor.report(os.Stderr)
+		if werr := writeJSON(tree, os.Stdout); err == nil {
+			err = werr
+		}
+		return err
+	}
+	if tree != nil {
+		tree.Print(os.Stdout)
+	}
+	redactor.report(os.Stdout)
+	return err
 }
 
 func main() {
-	var logFile string
-	var suiteName string
-	var reportName string
-	var reportProject string
-	var portalURL string
-	var skipTLS bool
-	var skipExisting bool
-	var ignoreErrors bool
-
-	t := time.Now()
-	flag.StringVar(&logFile, "file", "", "path to the logfile, will assume stdin if set to -")
-	flag.StringVar(&reportName, "launch", fmt.Sprintf("run%s", t.Format("20060102150405")), "name of the report")
-	flag.StringVar(&suiteName, "name", fmt.Sprintf("run%s", t.Format("20060102150405")), "name of the report")
-	flag.StringVar(&reportProject, "project", "gitops-adhoc", "project to upload to")
-	flag.StringVar(&portalURL, "url",
-		"https://reportportal-gitops-qe.apps.ocp-c1.prod.psi.redhat.com", "url of the report portal")
-	flag.BoolVar(&skipTLS, "skipTls", false, "skip TLS checks")
-	flag.BoolVar(&skipExisting, "skipExisting", false, "skip existing launches")
-	flag.BoolVar(&ignoreErrors, "ignoreErrors", false, "recover from all panics")
-
-	flag.Parse()
-
-	token, ok := os.LookupEnv("RP_TOKEN")
-	if !ok {
-		panic("RP_TOKEN env var needs to be set to authenticate")
-	}
-	run(portalURL, token, reportProject, reportName, suiteName, logFile, skipTLS, skipExisting, ignoreErrors)
+	if err := runCommand(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
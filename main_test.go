@@ -59,39 +59,68 @@ func (m *MockReportBuilder) getCase(name string) int {
 	return 1
 }
 
-func (m *MockReportBuilder) EnsureTest(name, startTime string) {
+func (m *MockReportBuilder) EnsureTest(name, startTime string) error {
 	if m.getCase(name) >= 0 {
-		return
+		return nil
 	}
 	m.Cases[name] = map[string][]map[string]string{
 		startTime: {{"c": "StartTest"}},
 	}
+	return nil
 }
 
-func (m *MockReportBuilder) AddLine(name, startTime, level, message string) {
-	m.EnsureTest(name, startTime)
+func (m *MockReportBuilder) AddLine(name, startTime, level, message string) error {
+	_ = m.EnsureTest(name, startTime)
 	m.Cases[name][startTime] = append(m.Cases[name][startTime], map[string]string{
 		"msg": message,
 	})
+	return nil
 }
 
-func (m *MockReportBuilder) FinnishTest(name, startTime, result, time string) {
-	m.EnsureTest(name, startTime)
+func (m *MockReportBuilder) FinnishTest(name, startTime, result, time string) error {
+	_ = m.EnsureTest(name, startTime)
 	m.Cases[name]["finished"] = []map[string]string{
 		{"result": result, "time": time},
 	}
+	return nil
 }
 
-func (m *MockReportBuilder) Finish(time string) {
+func (m *MockReportBuilder) EnsureStep(name, step, startTime string) error {
+	_ = m.EnsureTest(name, startTime)
+	if _, ok := m.Cases[name]["step "+step]; !ok {
+		m.Cases[name]["step "+step] = []map[string]string{{"start": startTime}}
+	}
+	return nil
+}
+
+func (m *MockReportBuilder) FinnishStep(name, step, endTime, result string) error {
+	_ = m.EnsureStep(name, step, endTime)
+	m.Cases[name]["step "+step] = append(m.Cases[name]["step "+step], map[string]string{
+		"end": endTime, "result": result,
+	})
+	return nil
+}
+
+func (m *MockReportBuilder) Attach(name, startTime, path string) error {
+	_ = m.EnsureTest(name, startTime)
+	m.Cases[name][startTime] = append(m.Cases[name][startTime], map[string]string{
+		"attach": path,
+	})
+	return nil
+}
+
+func (m *MockReportBuilder) Finish(time string) error {
 	m.FinishStamp = time
+	return nil
 }
 
-func (m *MockReportBuilder) EnsureLaunch(name, suite, startTime string) {
+func (m *MockReportBuilder) EnsureLaunch(name, suite, startTime string) error {
 	if m.getLaunch(name) >= 0 {
-		return
+		return nil
 	}
 	m.LaunchName = name
 	m.StartStamp = startTime
+	return nil
 }
 
 func mapToMatcher(m CasesType) Keys {
@@ -106,6 +135,15 @@ func mapToMatcher(m CasesType) Keys {
 	return o
 }
 
+// sequentialUUIDs makes the pre-generated uuids predictable for the recorded http log.
+func sequentialUUIDs() func() (string, error) {
+	n := 0
+	return func() (string, error) {
+		n++
+		return fmt.Sprintf("00000000-0000-4000-8000-%012d", n), nil
+	}
+}
+
 func mockOkJSON(v any) httpmock.Responder {
 	return httpmock.NewJsonResponderOrPanic(
 		200,
@@ -137,33 +175,63 @@ func (l *TestRestLogger) Debugf(format string, v ...interface{}) {
 	l.log = fmt.Sprintf("%s\n\n%s", l.log, s)
 }
 
+// expectGolden compares the cases to the expected json file, writing it if it does not exist yet.
+func expectGolden(actual *MockReportBuilder, expectedtFile string) {
+	file, err := os.OpenFile(expectedtFile, os.O_RDONLY, 0o666)
+	if errors.Is(err, os.ErrNotExist) {
+		b, errM := json.MarshalIndent(actual, "", "    ")
+		Expect(errM).To(BeNil())
+		out := string(b)
+		_, errW := script.Echo(out).WriteFile(expectedtFile)
+		Expect(errW).To(BeNil())
+	} else {
+		Expect(err).To(BeNil())
+		defer file.Close()
+		bytes, _ := ioutil.ReadAll(file)
+		expected := &MockReportBuilder{Cases: CasesType{}}
+		errU := json.Unmarshal(bytes, expected)
+		Expect(errU).To(BeNil())
+		Expect(actual.Cases).To(MatchAllKeys(mapToMatcher(expected.Cases)))
+	}
+}
+
 var _ = Describe("Testing parsing", func() {
 	dl := &DefaultLines{}
 
 	DescribeTable("Processing with MockReportBuilder", func(inputFile, expectedtFile string) {
 		actual := &MockReportBuilder{Cases: map[string]map[string][]map[string]string{}}
-		processLinear(actual, "TestName", "TestSuite", script.File(inputFile), true)
-		file, err := os.OpenFile(expectedtFile, os.O_RDONLY, 0o666)
-		if errors.Is(err, os.ErrNotExist) {
-			b, errM := json.MarshalIndent(actual, "", "    ")
-			Expect(errM).To(BeNil())
-			out := string(b)
-			_, errW := script.Echo(out).WriteFile(expectedtFile)
-			Expect(errW).To(BeNil())
-		} else {
-			Expect(err).To(BeNil())
-			defer file.Close()
-			bytes, _ := ioutil.ReadAll(file)
-			expected := &MockReportBuilder{Cases: CasesType{}}
-			errU := json.Unmarshal(bytes, expected)
-			Expect(errU).To(BeNil())
-			Expect(actual.Cases).To(MatchAllKeys(mapToMatcher(expected.Cases)))
-		}
+		errP := process(actual, "TestName", "TestSuite", script.File(inputFile),
+			&ParseOptions{Format: formatAuto, NoErrors: true})
+		Expect(errP).To(BeNil())
+		expectGolden(actual, expectedtFile)
 	},
 		Entry("Test parse kuttl-parllel",
 			"./test_data/parallel-kuttl.txt", "./test_data/parallel-kuttl.json"),
 		Entry("Test parse argocd-e2e",
 			"./test_data/argocd-e2e-186_last.log", "./test_data/argocd-e2e-186_last.json"),
+		Entry("Test parse go test -json",
+			"./test_data/go-test-json.log", "./test_data/go-test-json.json"),
+		Entry("Test parse junit xml",
+			"./test_data/kuttl-junit.xml", "./test_data/kuttl-junit.json"),
+		Entry("Test parse multi-line blocks",
+			"./test_data/blocks.txt", "./test_data/blocks.json"),
+		Entry("Test parse kuttl crossing midnight",
+			"./test_data/kuttl-midnight.txt", "./test_data/kuttl-midnight.json"),
+	)
+
+	DescribeTable("Detecting input format",
+		func(inputFile, expected string) {
+			actual, rest := detectFormat(script.File(inputFile))
+			Expect(actual).To(Equal(expected))
+			content, err := rest.String()
+			Expect(err).To(BeNil())
+			original, errR := os.ReadFile(inputFile)
+			Expect(errR).To(BeNil())
+			Expect(content).To(Equal(string(original)))
+		},
+		Entry("kuttl log", "./test_data/minimal-kuttl.txt", formatText),
+		Entry("go test -json", "./test_data/go-test-json.log", formatGoTestJSON),
+		Entry("junit xml", "./test_data/kuttl-junit.xml", formatJUnit),
 	)
 
 	DescribeTable("Matching withGetMatches",
@@ -181,7 +249,7 @@ var _ = Describe("Testing parsing", func() {
 			}),
 		Entry("Test reSTAMP",
 			dl.reSTAMP(), `  startTime: "2023-11-21T00:17:10Z"`,
-			Keys{"startDate": Equal("2023-11-21")}),
+			Keys{"startDate": Equal("2023-11-21"), "lastTime": Equal("2023-11-21T00:17:10Z")}),
 	)
 })
 
@@ -189,6 +257,7 @@ var _ = Describe("Testing mock upload", func() {
 	It("Exported", func() {
 		client.SetBaseURL("http://portal/")
 		client.SetDebug(true)
+		DeferCleanup(client.SetDebug, false)
 		l := &TestRestLogger{log: ""}
 		client.SetLogger(l)
 		tr := map[string]string{"id": "testid"}
@@ -223,8 +292,14 @@ var _ = Describe("Testing mock upload", func() {
 			mockOkJSON(tr))
 
 		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
-
-		processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/minimal-kuttl.txt"), true)
+		lg.newUUID = sequentialUUIDs()
+
+		errP := processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/minimal-kuttl.txt"),
+			&ParseOptions{NoErrors: true})
+		var report *ErrorReport
+		Expect(errors.As(errP, &report)).To(BeTrue())
+		Expect(report.Errors).To(ContainElement(MatchError(ContainSubstring(`bad time "2023-11-21T77:77:32Z"`))))
+		Expect(report.Errors).To(ContainElement(MatchError(ContainSubstring(`bad duration "asdf"`))))
 		file, err := os.OpenFile("./test_data/http_log", os.O_RDONLY, 0o666)
 		if errors.Is(err, os.ErrNotExist) {
 			_, errW := script.Echo(l.log).WriteFile("./test_data/http_log")
@@ -238,6 +313,74 @@ var _ = Describe("Testing mock upload", func() {
 	})
 })
 
+var _ = Describe("Testing error handling", func() {
+	It("Stops at the first error without ignoreErrors", func() {
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		errP := processLinear(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/minimal-kuttl.txt"),
+			&ParseOptions{})
+		var report *ErrorReport
+		Expect(errors.As(errP, &report)).To(BeTrue())
+		Expect(report.Errors).To(HaveLen(1))
+		var inputErr *InputError
+		Expect(errors.As(report.Errors[0], &inputErr)).To(BeTrue())
+		Expect(inputErr.Field).To(Equal("time"))
+		Expect(errP.Error()).To(ContainSubstring("line 6: bad time"))
+	})
+
+	DescribeTable("Reports non-2xx responses as portal errors",
+		func(status int, body string, expected Fields) {
+			client.SetBaseURL("http://portal/")
+			httpmock.RegisterResponder("POST", "http://portal/api/v1/TEST_PROJECT/launch",
+				mockOkJSON(map[string]string{"id": "testid"}))
+			httpmock.RegisterResponder("GET", "http://portal/api/v1/TEST_PROJECT/launch/testid",
+				mockOkJSON(map[string]string{"uuid": "launchuuid"}))
+			httpmock.RegisterResponder("POST", "http://portal/api/v1/TEST_PROJECT/item",
+				mockOkJSON(map[string]string{"id": "suiteid"}))
+			httpmock.RegisterResponder("GET", "http://portal/api/v1/TEST_PROJECT/item/suiteid",
+				mockOkJSON(map[string]string{"uuid": "suiteuuid"}))
+			httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item/suiteuuid",
+				httpmock.NewStringResponder(status, body))
+
+			lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+			Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10Z")).To(Succeed())
+			err := lg.EnsureTest("1-009_validate-manage-other-namespace", "2023-11-21T00:17:10Z")
+			var portalErr *PortalError
+			Expect(errors.As(err, &portalErr)).To(BeTrue())
+			Expect(*portalErr).To(MatchFields(IgnoreExtras, expected))
+			Expect(lg.Tests).To(BeEmpty())
+		},
+		Entry("400 with an error body", 400,
+			`{"errorCode": 4001, "message": "Incorrect Request. [Field 'name' should not be null.]"}`,
+			Fields{
+				"Method":     Equal("POST"),
+				"Path":       Equal("/api/v2/TEST_PROJECT/item/suiteuuid"),
+				"StatusCode": Equal(400),
+				"ErrorCode":  Equal(4001),
+				"Message":    Equal("Incorrect Request. [Field 'name' should not be null.]"),
+				"Item":       Equal("1-009_validate-manage-other-namespace"),
+			}),
+		Entry("401 with an error body", 401, `{"errorCode": 4003, "message": "Access is denied"}`,
+			Fields{"StatusCode": Equal(401), "ErrorCode": Equal(4003), "Message": Equal("Access is denied")}),
+		Entry("404 with an error body", 404, `{"errorCode": 40422, "message": "Test Item 'suiteuuid' not found."}`,
+			Fields{"StatusCode": Equal(404), "ErrorCode": Equal(40422)}),
+		Entry("500 without a json body", 500, `Internal Server Error`,
+			Fields{"StatusCode": Equal(500), "ErrorCode": Equal(0), "Message": BeEmpty()}),
+		Entry("503 from a proxy", 503, `<html><body>Service Unavailable</body></html>`,
+			Fields{"StatusCode": Equal(503), "Item": Equal("1-009_validate-manage-other-namespace")}),
+		Entry("200 without an id", 200, `{}`,
+			Fields{"StatusCode": Equal(200), "Err": MatchError(errNoID)}),
+	)
+
+	It("Describes the rejected request", func() {
+		err := &PortalError{
+			Method: "POST", Path: "/api/v2/TEST_PROJECT/item/suiteuuid", Status: "401 Unauthorized",
+			ErrorCode: 4003, Message: "Access is denied", Item: "1-009_validate",
+		}
+		Expect(err.Error()).To(Equal(
+			"POST /api/v2/TEST_PROJECT/item/suiteuuid (1-009_validate): 401 Unauthorized: error 4003: Access is denied"))
+	})
+})
+
 func TestAll(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Uploader Suite")
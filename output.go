@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Kinds of sinks the parsed report can be sent to with -output.
+const (
+	outputPortal = "reportportal"
+	outputJSON   = "json"
+	outputJUnit  = "junit"
+)
+
+// OutputSpec is a single -output value, either reportportal or kind=path of a file to write.
+type OutputSpec struct {
+	Kind string
+	Path string
+}
+
+// OutputFlag collects repeated -output flags, each of them can hold a comma separated list as well.
+type OutputFlag []string
+
+func (f *OutputFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *OutputFlag) Set(value string) error {
+	*f = append(*f, strings.Split(value, ",")...)
+	return nil
+}
+
+// parseOutputs turns the -output values into sinks, uploading to the portal when there are none.
+func parseOutputs(values []string) ([]OutputSpec, error) {
+	if len(values) == 0 {
+		return []OutputSpec{{Kind: outputPortal}}, nil
+	}
+	specs := []OutputSpec{}
+	for _, v := range values {
+		kind, path, _ := strings.Cut(strings.TrimSpace(v), "=")
+		switch kind {
+		case outputPortal:
+			if path != "" {
+				return nil, &InputError{Field: "output", Value: v, Err: fmt.Errorf("%s takes no path", kind)}
+			}
+		case outputJSON, outputJUnit:
+			if path == "" {
+				return nil, &InputError{Field: "output", Value: v, Err: fmt.Errorf("%s needs a path, i.e. %s=file", kind, kind)}
+			}
+		default:
+			return nil, &InputError{Field: "output", Value: v,
+				Err: fmt.Errorf("unknown kind %q, use %s, %s=file or %s=file", kind, outputPortal, outputJSON, outputJUnit)}
+		}
+		specs = append(specs, OutputSpec{Kind: kind, Path: path})
+	}
+	return specs, nil
+}
+
+// MultiBuilder reports to several builders at once, i.e. uploading while writing a junit file.
+type MultiBuilder []TestReportBuilder
+
+func (m MultiBuilder) each(call func(b TestReportBuilder) error) error {
+	var first error
+	for _, b := range m {
+		if err := call(b); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m MultiBuilder) getLaunch(name string) int {
+	for _, b := range m {
+		if b.getLaunch(name) >= 0 {
+			return 0
+		}
+	}
+	return -1
+}
+
+func (m MultiBuilder) getCase(name string) int {
+	for _, b := range m {
+		if b.getCase(name) >= 0 {
+			return 0
+		}
+	}
+	return -1
+}
+
+func (m MultiBuilder) EnsureLaunch(name, suite, startTime string) error {
+	return m.each(func(b TestReportBuilder) error { return b.EnsureLaunch(name, suite, startTime) })
+}
+
+func (m MultiBuilder) EnsureTest(name, startTime string) error {
+	return m.each(func(b TestReportBuilder) error { return b.EnsureTest(name, startTime) })
+}
+
+func (m MultiBuilder) AddLine(name, startTime, level, message string) error {
+	return m.each(func(b TestReportBuilder) error { return b.AddLine(name, startTime, level, message) })
+}
+
+func (m MultiBuilder) FinnishTest(name, startTime, result, t string) error {
+	return m.each(func(b TestReportBuilder) error { return b.FinnishTest(name, startTime, result, t) })
+}
+
+func (m MultiBuilder) EnsureStep(name, step, startTime string) error {
+	return m.each(func(b TestReportBuilder) error { return b.EnsureStep(name, step, startTime) })
+}
+
+func (m MultiBuilder) FinnishStep(name, step, endTime, result string) error {
+	return m.each(func(b TestReportBuilder) error { return b.FinnishStep(name, step, endTime, result) })
+}
+
+func (m MultiBuilder) Attach(name, startTime, path string) error {
+	return m.each(func(b TestReportBuilder) error { return b.Attach(name, startTime, path) })
+}
+
+func (m MultiBuilder) Finish(t string) error {
+	return m.each(func(b TestReportBuilder) error { return b.Finish(t) })
+}
+
+// ReportFile is a ReportTree written to a file once the launch is finished.
+type ReportFile struct {
+	*ReportTree
+	path   string
+	encode func(r *ReportTree, w io.Writer) error
+}
+
+func NewJSONReport(path string) *ReportFile {
+	return &ReportFile{ReportTree: NewReportTree(), path: path, encode: writeJSON}
+}
+
+func NewJUnitReport(path string) *ReportFile {
+	return &ReportFile{ReportTree: NewReportTree(), path: path, encode: writeJUnit}
+}
+
+func (f *ReportFile) Finish(t string) error {
+	if err := f.ReportTree.Finish(t); err != nil {
+		return err
+	}
+	file, err := os.Create(f.path)
+	if err != nil {
+		return err
+	}
+	if err := f.encode(f.ReportTree, file); err != nil {
+		file.Close()
+		return fmt.Errorf("writing %s: %w", f.path, err)
+	}
+	return file.Close()
+}
+
+func writeJSON(r *ReportTree, w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	return e.Encode(r)
+}
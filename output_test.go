@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/bitfield/script"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing outputs", func() {
+	DescribeTable("Parsing -output",
+		func(values []string, expected []OutputSpec) {
+			actual, err := parseOutputs(values)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal(expected))
+		},
+		Entry("uploads by default", nil, []OutputSpec{{Kind: outputPortal}}),
+		Entry("several sinks", []string{"reportportal", "junit=junit.xml"},
+			[]OutputSpec{{Kind: outputPortal}, {Kind: outputJUnit, Path: "junit.xml"}}),
+		Entry("files only", []string{"json=out.json", "junit=out.xml"},
+			[]OutputSpec{{Kind: outputJSON, Path: "out.json"}, {Kind: outputJUnit, Path: "out.xml"}}),
+	)
+
+	DescribeTable("Rejecting bad -output",
+		func(value, expected string) {
+			_, err := parseOutputs([]string{value})
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("unknown kind", "html=out.html", `unknown kind "html"`),
+		Entry("file without a path", "junit", "junit needs a path"),
+		Entry("portal with a path", "reportportal=x", "reportportal takes no path"),
+	)
+
+	It("Splits comma separated values", func() {
+		f := OutputFlag{}
+		Expect(f.Set("reportportal,json=a.json")).To(Succeed())
+		Expect(f.Set("junit=b.xml")).To(Succeed())
+		Expect([]string(f)).To(Equal([]string{"reportportal", "json=a.json", "junit=b.xml"}))
+	})
+
+	It("Writes json and junit in a single pass", func() {
+		dir := GinkgoT().TempDir()
+		jsonFile := filepath.Join(dir, "report.json")
+		junitFile := filepath.Join(dir, "junit.xml")
+		sinks := MultiBuilder{NewJSONReport(jsonFile), NewJUnitReport(junitFile)}
+		errP := process(sinks, "TestName", "TestSuite", script.File("./test_data/go-test-json.log"),
+			&ParseOptions{Format: formatAuto, NoErrors: true})
+		Expect(errP).To(BeNil())
+
+		jsonOut, err := os.ReadFile(jsonFile)
+		Expect(err).To(BeNil())
+		expectGoldenText(string(jsonOut), "./test_data/go-test-json.report.json")
+		junitOut, err := os.ReadFile(junitFile)
+		Expect(err).To(BeNil())
+		expectGoldenText(string(junitOut), "./test_data/go-test-json.junit.xml")
+
+		// reading the junit file back gives the same tests and results
+		original := NewReportTree()
+		Expect(process(original, "TestName", "TestSuite", script.File("./test_data/go-test-json.log"),
+			&ParseOptions{Format: formatAuto, NoErrors: true})).To(Succeed())
+		reread := NewReportTree()
+		Expect(process(reread, "TestName", "TestSuite", script.File(junitFile),
+			&ParseOptions{Format: formatAuto, NoErrors: true})).To(Succeed())
+		results := func(r *ReportTree) map[string]string {
+			m := map[string]string{}
+			for _, s := range r.Launch.Suites {
+				for _, t := range s.Tests {
+					m[s.Name+" "+t.Name] = t.Result
+				}
+			}
+			return m
+		}
+		Expect(results(reread)).To(Equal(results(original)))
+	})
+
+	It("Keeps reporting to the other sinks after one of them failed", func() {
+		actual := &MockReportBuilder{Cases: CasesType{}}
+		tree := NewReportTree()
+		sinks := MultiBuilder{tree, actual}
+		err := sinks.AddLine("test", "2023-11-21T00:17:10Z", "info", "hello")
+		Expect(err).To(MatchError(errNoLaunch))
+		Expect(actual.Cases).To(HaveKey("test"))
+	})
+})
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the secrets in the log lines.
+const redacted = "[REDACTED]"
+
+// RedactRule masks the secret capture group of its pattern, or the whole match when there is none.
+type RedactRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+	re      *regexp.Regexp
+}
+
+// Redactor masks the secrets in the log lines before they leave the machine.
+type Redactor struct {
+	Rules []RedactRule `yaml:"rules"`
+	// counts are the secrets masked by every rule
+	counts map[string]int
+}
+
+// DefaultRedactor knows the secrets seen in CI logs: login passwords, tokens and keys.
+func DefaultRedactor() *Redactor {
+	r := &Redactor{Rules: []RedactRule{
+		// -p is only a password for the login commands, oc patch -p is not
+		{Name: "password", Pattern: `\blogin\b.*\s-p(?:=|\s+)(?P<secret>[^\s"']+)`},
+		{Name: "password", Pattern: `(?:^|\s)--(?:[\w-]+-)?password(?:=|\s+)(?P<secret>[^\s"'-][^\s"']*)`},
+		{Name: "bearer token", Pattern: `(?i)\bbearer\s+(?P<secret>[\w\-.~+/]+=*)`},
+		{Name: "kubeconfig client key", Pattern: `\bclient-(?:key|certificate)-data:\s*(?P<secret>\S+)`},
+		{Name: "aws access key", Pattern: `\b(?P<secret>(?:AKIA|ASIA)[0-9A-Z]{16})\b`},
+		{Name: "aws secret key", Pattern: `(?i)\baws_secret_access_key\s*[=:]\s*(?P<secret>[^\s"']+)`},
+		{Name: "secret env var", Pattern: `\b[A-Z0-9_]*(?:TOKEN|SECRET)[A-Z0-9_]*=(?P<secret>"[^"]*"|'[^']*'|[^\s"']+)`},
+	}}
+	if err := r.compile(); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// loadRedactor reads more rules from a yaml file, they are applied before the defaults.
+func loadRedactor(path string) (*Redactor, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading redactions: %w", err)
+	}
+	custom := &Redactor{}
+	if err := yaml.Unmarshal(b, custom); err != nil {
+		return nil, fmt.Errorf("parsing redactions %s: %w", path, err)
+	}
+	if err := custom.compile(); err != nil {
+		return nil, fmt.Errorf("invalid redactions %s: %w", path, err)
+	}
+	r := DefaultRedactor()
+	r.Rules = append(custom.Rules, r.Rules...)
+	return r, nil
+}
+
+func (r *Redactor) compile() error {
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Pattern
+		}
+		rule.re = re
+	}
+	r.counts = map[string]int{}
+	return nil
+}
+
+// redact masks the secrets of the message, counting them.
+func (r *Redactor) redact(message string) string {
+	return r.replace(message, true)
+}
+
+// mask masks the secrets of a line which is not reported, i.e. the one echoed, without counting them again.
+func (r *Redactor) mask(message string) string {
+	return r.replace(message, false)
+}
+
+func (r *Redactor) replace(message string, count bool) string {
+	for _, rule := range r.Rules {
+		group := rule.re.SubexpIndex("secret")
+		matches := rule.re.FindAllStringSubmatchIndex(message, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		b := &strings.Builder{}
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if group >= 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			if start < 0 || message[start:end] == redacted {
+				continue
+			}
+			b.WriteString(message[last:start])
+			b.WriteString(redacted)
+			last = end
+			if count {
+				r.counts[rule.Name]++
+			}
+		}
+		b.WriteString(message[last:])
+		message = b.String()
+	}
+	return message
+}
+
+// report prints how many secrets were masked, by the rules which masked them.
+func (r *Redactor) report(w io.Writer) {
+	total := 0
+	names := []string{}
+	for name, count := range r.counts {
+		total += count
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := []string{}
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%d %s", r.counts[name], name))
+	}
+	if total == 0 {
+		fmt.Fprintln(w, "Redacted 0 secrets")
+		return
+	}
+	fmt.Fprintf(w, "Redacted %d secrets: %s\n", total, strings.Join(counts, ", "))
+}
+
+// redactFilter is a TestReportBuilder masking the secrets of the log lines.
+type redactFilter struct {
+	TestReportBuilder
+	redactor *Redactor
+}
+
+func (f *redactFilter) AddLine(name, startTime, level, message string) error {
+	return f.TestReportBuilder.AddLine(name, startTime, level, f.redactor.redact(message))
+}
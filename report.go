@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ReportTree is a TestReportBuilder keeping the whole report in memory instead of uploading it,
+// checking the input the same way RPLogger does.
+type ReportTree struct {
+	Launch *LaunchNode `json:"launch,omitempty"`
+	suite  *SuiteNode
+	// FlattenSubtests prints go subtests like TestFoo/case_1 next to their parent instead of nested in it
+	FlattenSubtests bool `json:"-"`
+}
+
+type LaunchNode struct {
+	Name      string       `json:"name"`
+	StartTime string       `json:"startTime"`
+	EndTime   string       `json:"endTime,omitempty"`
+	Suites    []*SuiteNode `json:"suites"`
+}
+
+type SuiteNode struct {
+	Name      string      `json:"name"`
+	StartTime string      `json:"startTime"`
+	Tests     []*TestNode `json:"tests"`
+}
+
+type TestNode struct {
+	Name      string      `json:"name"`
+	StartTime string      `json:"startTime"`
+	EndTime   string      `json:"endTime,omitempty"`
+	Result    string      `json:"result,omitempty"`
+	Duration  float64     `json:"duration"`
+	Steps     []*StepNode `json:"steps,omitempty"`
+	Logs      []*LogNode  `json:"logs,omitempty"`
+	// Attachments are the paths of the files attached to the test
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// StepNode is a step of a test, i.e. of a kuttl test. Its logs are the ones of the test naming the step.
+type StepNode struct {
+	Name      string `json:"name"`
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime,omitempty"`
+	Result    string `json:"result,omitempty"`
+}
+
+type LogNode struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Step    string `json:"step,omitempty"`
+}
+
+func NewReportTree() *ReportTree {
+	return &ReportTree{}
+}
+
+func (r *ReportTree) getLaunch(name string) int {
+	if r.Launch != nil {
+		return 0
+	}
+	return -1
+}
+
+func (r *ReportTree) getCase(name string) int {
+	if r.suite == nil {
+		return -1
+	}
+	for i, t := range r.suite.Tests {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *ReportTree) EnsureLaunch(name, suite, startTime string) error {
+	if _, err := toUnix(startTime); err != nil {
+		return err
+	}
+	if r.Launch == nil {
+		r.Launch = &LaunchNode{Name: name, StartTime: startTime}
+	}
+	if r.suite != nil && r.suite.Name == suite {
+		return nil
+	}
+	for _, s := range r.Launch.Suites {
+		if s.Name == suite {
+			r.suite = s
+			return nil
+		}
+	}
+	r.suite = &SuiteNode{Name: suite, StartTime: startTime}
+	r.Launch.Suites = append(r.Launch.Suites, r.suite)
+	return nil
+}
+
+func (r *ReportTree) EnsureTest(name, startTime string) error {
+	if r.getCase(name) >= 0 {
+		return nil
+	}
+	if r.suite == nil {
+		return errNoLaunch
+	}
+	if _, err := toUnix(startTime); err != nil {
+		return err
+	}
+	if parent := parentTest(name); parent != "" && !r.FlattenSubtests {
+		// the parent is started first when its own run line is missing, as RPLogger does
+		if err := r.EnsureTest(parent, startTime); err != nil {
+			return err
+		}
+	}
+	r.suite.Tests = append(r.suite.Tests, &TestNode{Name: name, StartTime: startTime})
+	return nil
+}
+
+func (r *ReportTree) AddLine(name, startTime, level, message string) error {
+	if err := r.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	t := r.suite.Tests[r.getCase(name)]
+	l := &LogNode{Time: startTime, Level: level, Message: message}
+	if step := t.openStep(); step != nil {
+		l.Step = step.Name
+	}
+	t.Logs = append(t.Logs, l)
+	return nil
+}
+
+func (t *TestNode) step(name string) *StepNode {
+	for _, s := range t.Steps {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (t *TestNode) openStep() *StepNode {
+	if len(t.Steps) == 0 || t.Steps[len(t.Steps)-1].EndTime != "" {
+		return nil
+	}
+	return t.Steps[len(t.Steps)-1]
+}
+
+func (r *ReportTree) EnsureStep(name, step, startTime string) error {
+	if err := r.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	t := r.suite.Tests[r.getCase(name)]
+	if t.step(step) != nil {
+		return nil
+	}
+	if open := t.openStep(); open != nil {
+		open.EndTime, open.Result = startTime, "PASS"
+	}
+	t.Steps = append(t.Steps, &StepNode{Name: step, StartTime: startTime})
+	return nil
+}
+
+func (r *ReportTree) FinnishStep(name, step, endTime, result string) error {
+	if err := r.EnsureStep(name, step, endTime); err != nil {
+		return err
+	}
+	s := r.suite.Tests[r.getCase(name)].step(step)
+	if s.EndTime == "" {
+		s.EndTime, s.Result = endTime, result
+	}
+	return nil
+}
+
+func (r *ReportTree) Attach(name, startTime, path string) error {
+	if err := checkAttachment(path); err != nil {
+		return err
+	}
+	if err := r.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	t := r.suite.Tests[r.getCase(name)]
+	t.Attachments = append(t.Attachments, path)
+	return nil
+}
+
+func (r *ReportTree) FinnishTest(name, startTime, result, t string) error {
+	value, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return &InputError{Field: "duration", Value: t, Err: err}
+	}
+	if err := r.EnsureTest(name, startTime); err != nil {
+		return err
+	}
+	test := r.suite.Tests[r.getCase(name)]
+	if open := test.openStep(); open != nil {
+		open.EndTime, open.Result = startTime, result
+	}
+	test.EndTime = startTime
+	test.Result = result
+	test.Duration = value
+	return nil
+}
+
+func (r *ReportTree) Finish(t string) error {
+	if r.Launch == nil {
+		return errNoLaunch
+	}
+	if _, err := toUnix(t); err != nil {
+		return err
+	}
+	r.Launch.EndTime = t
+	return nil
+}
+
+// Print writes the launch, its suites and tests as an indented tree.
+func (r *ReportTree) Print(w io.Writer) {
+	if r.Launch == nil {
+		fmt.Fprintln(w, "no launch")
+		return
+	}
+	l := r.Launch
+	fmt.Fprintf(w, "launch %s %s\n", l.Name, spanOf(l.StartTime, l.EndTime))
+	for _, s := range l.Suites {
+		counts := map[string]int{}
+		for _, t := range s.Tests {
+			counts[t.Result]++
+		}
+		fmt.Fprintf(w, "  suite %s: %d tests, %d passed, %d failed, %d skipped, %d unfinished\n", s.Name,
+			len(s.Tests), counts["PASS"], counts["FAIL"], counts["SKIP"], counts[""])
+		for _, t := range s.Tests {
+			if r.FlattenSubtests || parentTest(t.Name) == "" {
+				r.printTest(w, s, t, t.Name, "    ")
+			}
+		}
+	}
+}
+
+// printTest writes the test with its steps and attachments, followed by its subtests nested in it.
+func (r *ReportTree) printTest(w io.Writer, s *SuiteNode, t *TestNode, name, indent string) {
+	fmt.Fprintf(w, "%s%-4s %s (%gs, %d lines)\n", indent, resultOf(t.Result), name, t.Duration, len(t.Logs))
+	for _, step := range t.Steps {
+		lines := 0
+		for _, l := range t.Logs {
+			if l.Step == step.Name {
+				lines++
+			}
+		}
+		fmt.Fprintf(w, "%s  %-4s %s (%s, %d lines)\n", indent, resultOf(step.Result), step.Name,
+			durationOf(step.StartTime, step.EndTime), lines)
+	}
+	for _, a := range t.Attachments {
+		fmt.Fprintf(w, "%s  file %s\n", indent, a)
+	}
+	if r.FlattenSubtests {
+		return
+	}
+	for _, sub := range s.Tests {
+		if parentTest(sub.Name) == t.Name {
+			r.printTest(w, s, sub, sub.Name[len(t.Name)+1:], indent+"  ")
+		}
+	}
+}
+
+// resultOf marks tests and steps which were never finished.
+func resultOf(result string) string {
+	if result == "" {
+		return "----"
+	}
+	return result
+}
+
+// durationOf is the time between start and end, a dash when one of them is missing.
+func durationOf(start, end string) string {
+	s, errS := time.Parse(time.RFC3339, start)
+	e, errE := time.Parse(time.RFC3339, end)
+	if errS != nil || errE != nil {
+		return "-"
+	}
+	return e.Sub(s).String()
+}
+
+// spanOf formats the start and end of the launch together with how long it took.
+func spanOf(start, end string) string {
+	if end == "" {
+		return start
+	}
+	s, errS := time.Parse(time.RFC3339, start)
+	e, errE := time.Parse(time.RFC3339, end)
+	if errS != nil || errE != nil {
+		return fmt.Sprintf("%s - %s", start, end)
+	}
+	return fmt.Sprintf("%s - %s (%s)", start, end, e.Sub(s))
+}
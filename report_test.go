@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/bitfield/script"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// expectGoldenText compares the output to the expected file, writing it if it does not exist yet.
+func expectGoldenText(actual, expectedFile string) {
+	expected, err := os.ReadFile(expectedFile)
+	if errors.Is(err, os.ErrNotExist) {
+		Expect(os.WriteFile(expectedFile, []byte(actual), 0o666)).To(Succeed())
+		return
+	}
+	Expect(err).To(BeNil())
+	Expect(actual).To(Equal(string(expected)))
+}
+
+var _ = Describe("Testing dry run", func() {
+	DescribeTable("Printing the report tree", func(inputFile, expectedFile string) {
+		tree := NewReportTree()
+		errP := process(tree, "TestName", "TestSuite", script.File(inputFile),
+			&ParseOptions{Format: formatAuto, NoErrors: true})
+		Expect(errP).To(BeNil())
+		out := &strings.Builder{}
+		tree.Print(out)
+		expectGoldenText(out.String(), expectedFile)
+	},
+		Entry("kuttl-parallel", "./test_data/parallel-kuttl.txt", "./test_data/parallel-kuttl.tree"),
+		Entry("go test -json with several packages", "./test_data/go-test-json.log", "./test_data/go-test-json.tree"),
+		Entry("kuttl steps", "./test_data/kuttl-steps.txt", "./test_data/kuttl-steps.tree"),
+		Entry("kuttl crossing midnight", "./test_data/kuttl-midnight.txt", "./test_data/kuttl-midnight.tree"),
+	)
+
+	It("Checks the input like the upload does", func() {
+		tree := NewReportTree()
+		errP := process(tree, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/minimal-kuttl.txt"),
+			&ParseOptions{NoErrors: true})
+		var report *ErrorReport
+		Expect(errors.As(errP, &report)).To(BeTrue())
+		Expect(report.Errors).To(ContainElement(MatchError(ContainSubstring(`bad time "2023-11-21T77:77:32Z"`))))
+		Expect(report.Errors).To(ContainElement(MatchError(ContainSubstring(`bad duration "asdf"`))))
+	})
+
+	It("Prints the subtests next to their parent with -flattenSubtests", func() {
+		tree := &ReportTree{FlattenSubtests: true}
+		Expect(process(tree, "TestName", "TestSuite", script.File("./test_data/go-test-json.log"),
+			&ParseOptions{Format: formatAuto})).To(Succeed())
+		out := &strings.Builder{}
+		tree.Print(out)
+		Expect(out.String()).To(ContainSubstring("    FAIL TestDivide (1.25s, 0 lines)\n    PASS TestDivide/by_one (0s, 1 lines)\n"))
+	})
+
+	It("Prints an empty report", func() {
+		out := &strings.Builder{}
+		NewReportTree().Print(out)
+		Expect(out.String()).To(Equal("no launch\n"))
+	})
+})
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// retryWaitTime is the first backoff step, it doubles with every attempt up to the max wait time.
+const retryWaitTime = 500 * time.Millisecond
+
+// configureRetries makes the client retry transport errors and responses of an overloaded portal,
+// waiting with a jittered exponential backoff or as long as the portal asks in Retry-After.
+func configureRetries(client *resty.Client, retries int, maxWait time.Duration) *resty.Client {
+	return client.
+		SetRetryCount(retries).
+		SetRetryWaitTime(retryWaitTime).
+		SetRetryMaxWaitTime(maxWait).
+		SetRetryAfter(retryAfter).
+		AddRetryCondition(isRetryable)
+}
+
+func isRetryable(resp *resty.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode() {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter honours the Retry-After header, either in seconds or as a http date.
+// Returning 0 makes resty fall back to the jittered backoff.
+func retryAfter(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+	header := resp.Header().Get("Retry-After")
+	if header == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait, nil
+		}
+	}
+	return 0, nil
+}
+
+// randomUUID generates a version 4 uuid, used to start items idempotently:
+// when a retried start reaches the portal twice, the second one is rejected instead of creating a duplicate.
+func randomUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating uuid: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
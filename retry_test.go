@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// sequenceResponder answers with the given statuses one after another, counting the calls.
+func sequenceResponder(calls *int, statuses ...int) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		status := statuses[len(statuses)-1]
+		if *calls < len(statuses) {
+			status = statuses[*calls]
+		}
+		*calls++
+		if status != http.StatusOK {
+			return httpmock.NewStringResponse(status, `{"errorCode": 5000, "message": "unavailable"}`), nil
+		}
+		return httpmock.NewJsonResponse(status, map[string]string{"id": "testid"})
+	}
+}
+
+var _ = Describe("Testing retries", func() {
+	var retrying *resty.Client
+	var lg *RPLogger
+
+	BeforeEach(func() {
+		retrying = resty.New().SetBaseURL("http://portal/")
+		httpmock.ActivateNonDefault(retrying.GetClient())
+		configureRetries(retrying, 2, 10*time.Millisecond).SetRetryWaitTime(time.Millisecond)
+		lg = NewRPLogger(retrying, "TOKEN", "TEST_PROJECT")
+		lg.newUUID = sequentialUUIDs()
+	})
+
+	DescribeTable("Retrying a start request",
+		func(statuses []int, expectedCalls int, expectedErr bool) {
+			calls := 0
+			httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item",
+				sequenceResponder(&calls, statuses...))
+			id, err := lg.cAsyncPortalItem("api/v2/TEST_PROJECT/item", "", &RPItem{Name: "test"})
+			Expect(calls).To(Equal(expectedCalls))
+			if expectedErr {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).To(BeNil())
+				Expect(id).ToNot(BeEmpty())
+			}
+		},
+		Entry("succeeds after a 503", []int{503, 200}, 2, false),
+		Entry("succeeds after a 502 and a 429", []int{502, 429, 200}, 3, false),
+		Entry("gives up after the configured retries", []int{503}, 3, true),
+		Entry("does not retry a rejected payload", []int{400}, 1, true),
+		Entry("does not retry a server error", []int{500}, 1, true),
+	)
+
+	It("Fails the start when no uuid can be generated", func() {
+		calls := 0
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item", sequenceResponder(&calls, 200))
+		lg.newUUID = func() (string, error) {
+			return "", errors.New("generating uuid: no entropy")
+		}
+		_, err := lg.cAsyncPortalItem("api/v2/TEST_PROJECT/item", "", &RPItem{Name: "test"})
+		Expect(err).To(MatchError("generating uuid: no entropy"))
+		Expect(calls).To(Equal(0))
+	})
+
+	It("Treats a conflict on a retried start as created", func() {
+		calls := 0
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item",
+			sequenceResponder(&calls, 504, 409))
+		item := &RPItem{Name: "test"}
+		id, err := lg.cAsyncPortalItem("api/v2/TEST_PROJECT/item", "", item)
+		Expect(err).To(BeNil())
+		Expect(id).To(Equal("00000000-0000-4000-8000-000000000001"))
+		Expect(item.UUID).To(Equal(id))
+	})
+
+	It("Does not hide a conflict on the first attempt", func() {
+		calls := 0
+		httpmock.RegisterResponder("POST", "http://portal/api/v2/TEST_PROJECT/item",
+			sequenceResponder(&calls, 409))
+		_, err := lg.cAsyncPortalItem("api/v2/TEST_PROJECT/item", "", &RPItem{Name: "test"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("Honouring Retry-After",
+		func(header string, expected time.Duration) {
+			resp := &resty.Response{RawResponse: &http.Response{Header: http.Header{}}}
+			if header != "" {
+				resp.RawResponse.Header.Set("Retry-After", header)
+			}
+			wait, err := retryAfter(nil, resp)
+			Expect(err).To(BeNil())
+			Expect(wait).To(BeNumerically("~", expected, 2*time.Second))
+		},
+		Entry("no header falls back to the backoff", "", time.Duration(0)),
+		Entry("seconds", "7", 7*time.Second),
+		Entry("garbage falls back to the backoff", "soon", time.Duration(0)),
+	)
+
+	It("Honours Retry-After as a http date", func() {
+		// the date is taken when the spec runs, an Entry would be evaluated long before that
+		resp := &resty.Response{RawResponse: &http.Response{Header: http.Header{}}}
+		resp.RawResponse.Header.Set("Retry-After", time.Now().Add(20*time.Second).UTC().Format(http.TimeFormat))
+		wait, err := retryAfter(nil, resp)
+		Expect(err).To(BeNil())
+		Expect(wait).To(BeNumerically("~", 20*time.Second, 2*time.Second))
+	})
+
+	It("Generates version 4 uuids", func() {
+		first, err := randomUUID()
+		Expect(err).To(BeNil())
+		Expect(first).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		second, err := randomUUID()
+		Expect(err).To(BeNil())
+		Expect(second).ToNot(Equal(first))
+	})
+})
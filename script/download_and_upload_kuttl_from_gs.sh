@@ -1,6 +1,8 @@
+# Uploads the last kuttl runs of the periodic job $NAME with the nightly profile of the config,
+# see config.example.yaml; RP_CONFIG=../config.example.yaml uses the example as it is, RP_TOKEN has the token then.
 cd  kuttl-$NAME
 gsutil -m ls gs://origin-ci-test/logs/periodic-ci-redhat-developer-gitops-operator-master-v4.12-periodic-kuttl-$NAME/ | awk -F  "/" '{print $6}' | sort | tail -n10 |  xargs -I '{}' mkdir -p {}
 find . -type d -empty -exec echo {} \; | awk -F  "/" '{print $2}' | grep -v latest | xargs -I {} gsutil -m cp -r gs://origin-ci-test/logs/periodic-ci-redhat-developer-gitops-operator-master-v4.12-periodic-kuttl-$NAME/{}/artifacts/periodic-kuttl-$NAME/$NAME-e2e-steps/ {}
 find . -type d -empty -print -delete
 cd ..
-ls kuttl-$NAME | grep -v latest | xargs -P 16 -I '{}' ../log2reportportal -launch {} -name $NAME-kuttl -project gitops-nightly -skipExisting -skipTls -file kuttl-$NAME/{}/$NAME-e2e-steps/build-log.txt 
+ls kuttl-$NAME | grep -v latest | xargs -P 16 -I '{}' ../log2reportportal -profile nightly -launch {} -name $NAME-kuttl -file kuttl-$NAME/{}/$NAME-e2e-steps/build-log.txt 
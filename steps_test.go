@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/bitfield/script"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing kuttl steps", func() {
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		registerPortal()
+	})
+
+	It("Reports steps as children of their test, failing the step the test failed in", func() {
+		r := newEventRecorder()
+		r.register()
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		lg.LaunchLogs = false
+		lg.Batch = LogBatchOptions{MaxCount: 1, MaxBytes: 1 << 20, MaxWait: 0}
+		errP := process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/kuttl-steps.txt"),
+			&ParseOptions{NoErrors: true})
+		Expect(errP).To(BeNil())
+		finished := map[string]string{}
+		logs := map[string]int{}
+		for id, events := range r.events {
+			for _, e := range events {
+				if e == "log" {
+					logs[r.ids[id]]++
+				} else if e != "start" {
+					finished[r.ids[id]] = e
+				}
+			}
+		}
+		Expect(finished).To(Equal(map[string]string{
+			"1-001_install":                 "finish passed",
+			"1-001_install/1-install":       "finish passed",
+			"1-001_install/2-check":         "finish passed",
+			"1-002_label":                   "finish failed",
+			"1-002_label/1-install":         "finish passed",
+			"1-002_label/2-label-namespace": "finish failed",
+			"1-003_check":                   "finish failed",
+			"1-003_check/1-install":         "finish passed",
+			"1-003_check/2-wait":            "finish failed",
+			"kuttl":                         "finish failed",
+			"kuttl/harness":                 "finish failed",
+		}))
+		Expect(logs).To(HaveKeyWithValue("1-002_label/2-label-namespace", 3))
+		Expect(logs).To(HaveKeyWithValue("1-002_label", 4))
+	})
+})
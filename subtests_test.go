@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/bitfield/script"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// finishedItems uploads the log, returning how every item ended by its path. Logs are flushed at the end, so they
+// are left out.
+func finishedItems(flatten bool) (*eventRecorder, map[string]string) {
+	r := newEventRecorder()
+	r.register()
+	lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+	lg.FlattenSubtests = flatten
+	lg.LaunchLogs = false
+	errP := process(lg, "REPORT_NAME", "REPORT_SUITE", script.File("./test_data/go-test-json-nested.log"),
+		&ParseOptions{NoErrors: true})
+	Expect(errP).To(BeNil())
+	finished := map[string]string{}
+	for id, events := range r.events {
+		for _, e := range events {
+			if e != "log" {
+				finished[r.ids[id]] = e
+			}
+		}
+	}
+	return r, finished
+}
+
+var _ = Describe("Testing go subtests", func() {
+	BeforeEach(func() {
+		client.SetBaseURL("http://portal/")
+		registerPortal()
+	})
+
+	It("Nests subtests in their parent, deriving the status of parents without their own result", func() {
+		r, finished := finishedItems(false)
+		Expect(finished).To(Equal(map[string]string{
+			"TestParse":            "finish failed",
+			"TestParse/case_1":     "finish passed",
+			"TestParse/case_1/sub": "finish passed",
+			"TestParse/case_2":     "finish failed",
+			"TestParse/case_2/sub": "finish failed",
+			"TestParse/case_3":     "finish skipped",
+		}))
+		for id, path := range r.ids {
+			if path == "TestParse" {
+				Expect(r.parents[id]).To(Equal("testid"))
+			} else {
+				Expect(r.ids).To(HaveKey(r.parents[id]), path)
+			}
+		}
+	})
+
+	It("Keeps subtests next to their parent when flattening", func() {
+		r, finished := finishedItems(true)
+		Expect(finished).To(Equal(map[string]string{
+			"TestParse":            "start",
+			"TestParse/case_1":     "finish passed",
+			"TestParse/case_1/sub": "finish passed",
+			"TestParse/case_2":     "start",
+			"TestParse/case_2/sub": "finish failed",
+			"TestParse/case_3":     "finish skipped",
+		}))
+		Expect(r.parents).To(HaveLen(6))
+		for _, parent := range r.parents {
+			Expect(parent).To(Equal("testid"))
+		}
+	})
+})
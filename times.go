@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bitfield/script"
+)
+
+// timeLayouts are the timestamp layouts understood in logs and reports, the ones without a zone
+// are in the time zone given by -tz.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006/01/02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+}
+
+// clockLayout is the HH:MM:SS of the kuttl logger, glued onto the date of the startTime line.
+const clockLayout = "2006-01-02T15:04:05.999999999"
+
+// rolloverThreshold tells a clock going backwards past midnight from the out of order lines of parallel tests,
+// which kuttl prints once a test is done.
+const rolloverThreshold = 12 * time.Hour
+
+// parseTime tries the known layouts, the time is in loc unless it names its zone.
+func parseTime(stamp string, loc *time.Location) (time.Time, error) {
+	var first error
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, stamp, loc)
+		if err == nil {
+			return t, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return time.Time{}, &InputError{Field: "time", Value: stamp, Err: first}
+}
+
+// formatTime is how times are passed to the TestReportBuilder, in UTC and keeping the fractional seconds.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func toUnix(startTime string) (int, error) {
+	tt, err := parseTime(startTime, time.UTC)
+	if err != nil {
+		return 0, err
+	}
+	return int(tt.UnixNano() / int64(time.Millisecond)), nil // api needs milliseconds
+}
+
+// lineTime is the time of a line from the captures of the grammar: a whole datetime,
+// or a timestamp with its date, which is the date of the startTime line for the kuttl logger.
+// Lines without a date of their own roll over to the next day when the clock goes back past midnight.
+// The unparsable times are returned as they are, to be rejected by the builder.
+func lineTime(s, m map[string]string, loc *time.Location) string {
+	if m["datetime"] != "" {
+		if t, err := parseTime(m["datetime"], loc); err == nil {
+			return formatTime(t)
+		}
+		return m["datetime"]
+	}
+	date := m["date"]
+	if date == "" {
+		date = s["startDate"]
+	}
+	raw := fmt.Sprintf("%sT%s", date, m["timestamp"])
+	t, err := time.ParseInLocation(clockLayout, raw, loc)
+	if err != nil {
+		return raw + "Z"
+	}
+	if m["date"] == "" && s["lastTime"] != "" {
+		if last, err := parseTime(s["lastTime"], loc); err == nil {
+			t = rollover(t, last)
+		}
+	}
+	return formatTime(t)
+}
+
+// tick is the time of a line without one of its own, a millisecond after the previous line
+// so the portal keeps the lines in order.
+func tick(s map[string]string, loc *time.Location) string {
+	t, err := parseTime(s["time"], loc)
+	if err != nil {
+		return s["time"]
+	}
+	return formatTime(t.Add(time.Millisecond))
+}
+
+// reDateTime finds the times with a date anywhere in a line, i.e. in time="2023-11-21T00:17:10Z".
+var reDateTime = regexp.MustCompile(`\d{4}-\d\d-\d\d[T ]\d\d:\d\d:\d\d(?:\.\d+)?(?:Z|[+-]\d\d:\d\d)?`)
+
+// The sources of the start time of a log without -startTime, tried in the order given by -startTimeFrom.
+const (
+	startFromMtime = "mtime"
+	startFromLog   = "log"
+	startFromNow   = "now"
+)
+
+// DefaultStartTimeFrom is the modification time of the file, then the first time in the log, then now.
+const DefaultStartTimeFrom = startFromMtime + "," + startFromLog + "," + startFromNow
+
+// parseStartTimeFrom checks the comma separated sources of -startTimeFrom.
+func parseStartTimeFrom(value string) ([]string, error) {
+	if value == "" {
+		value = DefaultStartTimeFrom
+	}
+	sources := strings.Split(value, ",")
+	for i, source := range sources {
+		sources[i] = strings.TrimSpace(source)
+		switch sources[i] {
+		case startFromMtime, startFromLog, startFromNow:
+		default:
+			return nil, &InputError{Field: "start time source", Value: source,
+				Err: fmt.Errorf("use %s, %s or %s", startFromMtime, startFromLog, startFromNow)}
+		}
+	}
+	return sources, nil
+}
+
+// startOf is the start of a log without -startTime, from the first of the sources having a time,
+// the ones of DefaultStartTimeFrom when nil. The returned pipe yields the whole log again.
+func startOf(filePipe *script.Pipe, loc *time.Location, sources []string, fileTime time.Time,
+) (time.Time, *script.Pipe, error) {
+	if sources == nil {
+		sources = []string{startFromMtime, startFromLog, startFromNow}
+	}
+	for _, source := range sources {
+		switch source {
+		case startFromMtime:
+			if !fileTime.IsZero() {
+				return fileTime, filePipe, nil
+			}
+		case startFromLog:
+			t, rest, err := firstTime(filePipe, loc)
+			if err != nil {
+				return time.Time{}, nil, err
+			}
+			filePipe = rest
+			if !t.IsZero() {
+				return t, filePipe, nil
+			}
+		case startFromNow:
+			return time.Now(), filePipe, nil
+		}
+	}
+	return time.Time{}, nil, &InputError{Field: "start time", Value: strings.Join(sources, ","),
+		Err: fmt.Errorf("none of the sources has a time, pass -startTime or add %s", startFromNow)}
+}
+
+// firstTime finds the first parsable time in the log, reading it only up to the line with that time,
+// so that a log streamed into the parser is not held back. The returned pipe yields the whole log again.
+// It is zero when the log has no time at all.
+func firstTime(filePipe *script.Pipe, loc *time.Location) (time.Time, *script.Pipe, error) {
+	br := bufio.NewReader(filePipe)
+	read := &strings.Builder{}
+	var found time.Time
+	for found.IsZero() {
+		line, err := br.ReadString('\n')
+		read.WriteString(line)
+		for _, stamp := range reDateTime.FindAllString(line, -1) {
+			if t, err := parseTime(stamp, loc); err == nil {
+				found = t
+				break
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+	}
+	return found, script.NewPipe().WithReader(io.MultiReader(strings.NewReader(read.String()), br)), nil
+}
+
+// rollover moves the time to the day closest to the last one.
+func rollover(t, last time.Time) time.Time {
+	for last.Sub(t) > rolloverThreshold {
+		t = t.AddDate(0, 0, 1)
+	}
+	for t.Sub(last) > rolloverThreshold {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
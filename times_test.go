@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bitfield/script"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// kuttlLines is a kuttl log started at startTime, the lines are added to the harness output as they are.
+func kuttlLines(startTime string, lines ...string) *script.Pipe {
+	log := "  startTime: \"" + startTime + "\"\n=== RUN   kuttl/harness/1-001_install\n"
+	for _, l := range lines {
+		log += l + "\n"
+	}
+	return script.Echo(log)
+}
+
+func logTimes(tree *ReportTree, test string) []string {
+	times := []string{}
+	for _, l := range tree.suite.Tests[tree.getCase(test)].Logs {
+		times = append(times, l.Time)
+	}
+	return times
+}
+
+var _ = Describe("Testing timestamps", func() {
+	DescribeTable("Parsing the known layouts", func(stamp, expected string) {
+		t, err := parseTime(stamp, time.UTC)
+		Expect(err).To(BeNil())
+		Expect(formatTime(t)).To(Equal(expected))
+	},
+		Entry("RFC3339", "2023-11-21T00:17:10Z", "2023-11-21T00:17:10Z"),
+		Entry("RFC3339Nano", "2023-11-21T00:17:10.883709089Z", "2023-11-21T00:17:10.883709089Z"),
+		Entry("offset", "2023-11-21T01:17:10.5+01:00", "2023-11-21T00:17:10.5Z"),
+		Entry("without a zone", "2023-11-21T00:17:10.25", "2023-11-21T00:17:10.25Z"),
+		Entry("space separated", "2023-11-21 00:17:10", "2023-11-21T00:17:10Z"),
+		Entry("slashes", "2023/11/21 00:17:10.123", "2023-11-21T00:17:10.123Z"),
+		Entry("RFC1123", "Tue, 21 Nov 2023 00:17:10 UTC", "2023-11-21T00:17:10Z"),
+		Entry("date", "Tue Nov 21 00:17:10 UTC 2023", "2023-11-21T00:17:10Z"),
+	)
+
+	It("Rejects unknown layouts", func() {
+		_, err := parseTime("21.11.2023 00:17", time.UTC)
+		Expect(err).To(MatchError(ContainSubstring(`bad time "21.11.2023 00:17"`)))
+	})
+
+	It("Keeps the milliseconds for the api", func() {
+		Expect(toUnix("2023-11-21T00:17:10.883709089Z")).To(Equal(1700525830883))
+		Expect(toUnix("2023-11-21T00:17:10Z")).To(Equal(1700525830000))
+	})
+
+	It("Reads the times without a zone in the given one", func() {
+		tree := NewReportTree()
+		Expect(process(tree, "REPORT_NAME", "REPORT_SUITE", kuttlLines("2023-11-21T00:17:10Z",
+			"    logger.go:42: 01:19:32.250 | 1-001_install | Creating namespace: kuttl-test"),
+			&ParseOptions{Location: time.FixedZone("CET", 3600)})).To(Succeed())
+		Expect(logTimes(tree, "1-001_install")).To(Equal([]string{"2023-11-21T00:19:32.25Z"}))
+	})
+
+	It("Rolls the kuttl clock over to the next day", func() {
+		tree := NewReportTree()
+		Expect(process(tree, "REPORT_NAME", "REPORT_SUITE", kuttlLines("2023-11-21T23:59:00Z",
+			"    logger.go:42: 23:59:59 | 1-001_install | Creating namespace: kuttl-test",
+			// parallel tests print their lines once they are done, a few seconds back is not a new day
+			"    logger.go:42: 23:59:50 | 1-001_install | Created namespace",
+			"    logger.go:42: 00:00:01 | 1-001_install | Deleting namespace: kuttl-test",
+			"    logger.go:42: 00:00:02 | 1-001_install | Deleted namespace"),
+			&ParseOptions{})).To(Succeed())
+		Expect(logTimes(tree, "1-001_install")).To(Equal([]string{
+			"2023-11-21T23:59:59Z", "2023-11-21T23:59:50Z", "2023-11-22T00:00:01Z", "2023-11-22T00:00:02Z",
+		}))
+	})
+
+	It("Rolls over from the startTime of the log", func() {
+		tree := NewReportTree()
+		Expect(process(tree, "REPORT_NAME", "REPORT_SUITE", kuttlLines("2023-11-21T23:59:50Z",
+			"    logger.go:42: 00:00:02 | 1-001_install | Creating namespace: kuttl-test"),
+			&ParseOptions{})).To(Succeed())
+		Expect(logTimes(tree, "1-001_install")).To(Equal([]string{"2023-11-22T00:00:02Z"}))
+	})
+
+	It("Takes the date of a log without a startTime line from the start time", func() {
+		tree := NewReportTree()
+		Expect(process(tree, "REPORT_NAME", "REPORT_SUITE", script.Echo(
+			"=== RUN   kuttl/harness/1-001_install\n"+
+				"    logger.go:42: 00:19:32 | 1-001_install | Creating namespace: kuttl-test\n"),
+			&ParseOptions{StartTime: time.Date(2023, 11, 21, 0, 30, 0, 0, time.UTC)})).To(Succeed())
+		Expect(logTimes(tree, "1-001_install")).To(Equal([]string{"2023-11-21T00:19:32Z"}))
+	})
+
+	It("Starts from the first time found in the log", func() {
+		tree := NewReportTree()
+		Expect(process(tree, "REPORT_NAME", "REPORT_SUITE", script.Echo(
+			"=== RUN   TestLogin\n"+
+				"    opening the browser\n"+
+				"    typing credentials\n"+
+				`time="2022-08-02T16:35:54Z" level=info msg="logged in"`+"\n"),
+			&ParseOptions{})).To(Succeed())
+		Expect(logTimes(tree, "TestLogin")).To(Equal([]string{"2022-08-02T16:35:54.001Z", "2022-08-02T16:35:54.002Z"}))
+	})
+
+	It("Reads a streamed log only up to its first time", func() {
+		r, w := io.Pipe()
+		defer w.Close()
+		go func() {
+			_, _ = io.WriteString(w, "=== RUN   TestLogin\n    opening the browser\n"+
+				`time="2022-08-02T16:35:54Z" level=info msg="logged in"`+"\n")
+		}()
+		// the rest of the log is not written yet, the start is known anyway
+		start, rest, err := firstTime(script.NewPipe().WithReader(r), time.UTC)
+		Expect(err).To(BeNil())
+		Expect(formatTime(start)).To(Equal("2022-08-02T16:35:54Z"))
+		go func() {
+			_, _ = io.WriteString(w, "--- PASS: TestLogin (0.00s)\n")
+			w.Close()
+		}()
+		content, err := rest.String()
+		Expect(err).To(BeNil())
+		Expect(content).To(HavePrefix("=== RUN   TestLogin\n"))
+		Expect(content).To(HaveSuffix("logged in\"\n--- PASS: TestLogin (0.00s)\n"))
+	})
+
+	It("Reads -startTime and the modification time of the file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "kuttl.log")
+		Expect(os.WriteFile(path, []byte("=== RUN   kuttl\n"), 0o666)).To(Succeed())
+		mtime := time.Date(2023, 11, 21, 0, 17, 10, 0, time.UTC)
+		Expect(os.Chtimes(path, mtime, mtime)).To(Succeed())
+
+		start, err := (&UploadOptions{LogFile: path, StartTime: "2023-11-21 01:17:10"}).startTime(
+			time.FixedZone("CET", 3600))
+		Expect(err).To(BeNil())
+		Expect(formatTime(start)).To(Equal("2023-11-21T00:17:10Z"))
+		start, err = (&UploadOptions{LogFile: path}).startTime(time.UTC)
+		Expect(err).To(BeNil())
+		Expect(start.IsZero()).To(BeTrue())
+		_, err = (&UploadOptions{LogFile: path, StartTime: "yesterday"}).startTime(time.UTC)
+		Expect(err).To(MatchError(ContainSubstring(`bad time "yesterday"`)))
+
+		fileTime, err := (&UploadOptions{LogFile: path}).fileTime()
+		Expect(err).To(BeNil())
+		Expect(fileTime.Equal(mtime)).To(BeTrue())
+		fileTime, err = (&UploadOptions{LogFile: "-"}).fileTime()
+		Expect(err).To(BeNil())
+		Expect(fileTime.IsZero()).To(BeTrue())
+	})
+
+	It("Finishes the tests with millisecond durations", func() {
+		client.SetBaseURL("http://portal/")
+		registerPortal()
+		finished := []*RPFinishItem{}
+		httpmock.RegisterResponder("PUT", "http://portal/api/v1/TEST_PROJECT/item/testid",
+			func(req *http.Request) (*http.Response, error) {
+				f := &RPFinishItem{}
+				Expect(json.NewDecoder(req.Body).Decode(f)).To(Succeed())
+				finished = append(finished, f)
+				return httpmock.NewJsonResponse(200, map[string]string{"id": "testid"})
+			})
+		lg := NewRPLogger(client, "TOKEN", "TEST_PROJECT")
+		Expect(lg.EnsureLaunch("REPORT_NAME", "REPORT_SUITE", "2023-11-21T00:17:10.100Z")).To(Succeed())
+		Expect(lg.FinnishTest("TestDivide", "2023-11-21T00:17:10.100Z", "PASS", "1.255")).To(Succeed())
+		Expect(finished).To(HaveLen(1))
+		Expect(finished[0].EndTime).To(Equal(1700525830100 + 1255))
+	})
+})
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// errNoToken is returned when a command talking to the portal has no token from any of its sources.
+var errNoToken = errors.New(
+	"no token to authenticate with, pass -tokenFile or -tokenCommand, set RP_TOKEN or token in the config")
+
+// sourceRank orders the sources of the settings, the higher one overrides the lower one.
+func sourceRank(source string) int {
+	switch {
+	case source == "flag":
+		return 4
+	case strings.HasPrefix(source, "env "):
+		return 3
+	case strings.HasPrefix(source, "profile "):
+		return 2
+	case source == "config":
+		return 1
+	}
+	return 0
+}
+
+// resolveToken sets the token from the source given by the highest settings layer:
+// the token itself, a file holding it, i.e. a mounted secret, or a credential helper printing it.
+func (c *ConnectionOptions) resolveToken(s *Settings) error {
+	values := map[string]string{"token": s.Token, "tokenFile": c.TokenFile, "tokenCommand": c.TokenCommand}
+	sources := map[string]int{}
+	for name, value := range values {
+		if value != "" {
+			sources[name] = sourceRank(s.sources[name])
+		}
+	}
+	best, rank := "", 0
+	for _, name := range []string{"token", "tokenFile", "tokenCommand"} {
+		switch {
+		case sources[name] > rank:
+			best, rank = name, sources[name]
+		case sources[name] == rank && rank > 0:
+			return &InputError{Field: "token", Err: fmt.Errorf("both %s and %s are set in %s, keep one of them",
+				best, name, s.sources[name])}
+		}
+	}
+
+	token := ""
+	switch best {
+	case "":
+		return errNoToken
+	case "token":
+		token = s.Token
+	case "tokenFile":
+		b, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return &InputError{Field: "token file", Value: c.TokenFile, Err: err}
+		}
+		token = string(b)
+	case "tokenCommand":
+		// the helper may ask for a login on the terminal, only its stdout is the token
+		cmd := exec.Command("sh", "-c", c.TokenCommand)
+		cmd.Stderr = os.Stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return &InputError{Field: "token command", Value: c.TokenCommand, Err: err}
+		}
+		token = string(b)
+	}
+	c.Token = strings.TrimSpace(token)
+	if c.Token == "" {
+		return &InputError{Field: "token", Err: fmt.Errorf("the %s from %s is empty", best, s.sources[best])}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing the token sources", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		GinkgoT().Setenv("HOME", dir)
+		if value, ok := os.LookupEnv("RP_TOKEN"); ok {
+			Expect(os.Unsetenv("RP_TOKEN")).To(Succeed())
+			DeferCleanup(os.Setenv, "RP_TOKEN", value)
+		}
+	})
+
+	// resolve picks the token of a config with the given content and of the flags
+	resolve := func(config string, args ...string) (string, error) {
+		path := filepath.Join(dir, "config.yaml")
+		Expect(os.WriteFile(path, []byte(config), 0o644)).To(Succeed())
+		c := &ConnectionOptions{}
+		fs := flag.NewFlagSet("launches", flag.ContinueOnError)
+		c.addFlags(fs)
+		s, err := parseFlags(fs, append([]string{"-config", path}, args...), connectionFlags...)
+		Expect(err).To(BeNil())
+		err = c.resolveToken(s)
+		return c.Token, err
+	}
+
+	It("Reads the token from a file given by a flag over the environment", func() {
+		path := filepath.Join(dir, "token")
+		Expect(os.WriteFile(path, []byte("file-token\n"), 0o600)).To(Succeed())
+		GinkgoT().Setenv("RP_TOKEN", "env-token")
+		Expect(resolve("", "-tokenFile", path)).To(Equal("file-token"))
+		Expect(resolve("")).To(Equal("env-token"))
+	})
+
+	It("Runs the credential helper of the profile over the token of the config", func() {
+		config := "token: config-token\nprofiles:\n  nightly:\n    tokenCommand: echo helper-token\n"
+		Expect(resolve(config, "-profile", "nightly")).To(Equal("helper-token"))
+		Expect(resolve(config)).To(Equal("config-token"))
+	})
+
+	It("Explains why there is no token", func() {
+		_, err := resolve("")
+		Expect(err).To(MatchError(errNoToken))
+		_, err = resolve("token: config-token\ntokenFile: /run/secrets/token\n")
+		Expect(err).To(MatchError("bad token: both token and tokenFile are set in config, keep one of them"))
+		_, err = resolve("", "-tokenFile", filepath.Join(dir, "missing"))
+		Expect(err).To(MatchError(ContainSubstring(`bad token file "` + filepath.Join(dir, "missing") + `"`)))
+		_, err = resolve("", "-tokenCommand", "exit 3")
+		Expect(err).To(MatchError(`bad token command "exit 3": exit status 3`))
+		_, err = resolve("", "-tokenCommand", "true")
+		Expect(err).To(MatchError("bad token: the tokenCommand from flag is empty"))
+	})
+
+	It("Fails the upload without a token instead of panicking, unless it needs none", func() {
+		args := []string{"upload", "-url", "http://portal", "-project", "TEST_PROJECT",
+			"-file", "./test_data/go-test-json.log"}
+		Expect(runCommand(args)).To(MatchError(errNoToken))
+		Expect(runCommand(append(args, "-dry-run"))).To(Succeed())
+	})
+})